@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.etcd.io/bbolt"
+)
+
+// values larger than this are only recorded by hash
+const auditValueLimit = 4096
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time     time.Time   `json:"time"`
+	User     string      `json:"user"`
+	Remote   string      `json:"remote,omitempty"`
+	Database string      `json:"database"`
+	Op       string      `json:"op"`
+	Path     auditPath   `json:"path"`
+	To       auditPath   `json:"to,omitempty"`
+	Detail   string      `json:"detail,omitempty"`
+	Old      *auditValue `json:"old,omitempty"`
+	New      *auditValue `json:"new,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// auditValue is a key value or, for buckets, a hash of the bucket contents.
+type auditValue struct {
+	Bucket   bool   `json:"bucket,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	Size     int    `json:"size"`
+	SHA256   string `json:"sha256"`
+	Value    string `json:"value,omitempty"`
+	Base64   bool   `json:"base64,omitempty"`
+}
+
+// auditPath is recorded as a list of names, so names holding "/" or binary
+// data survive; names which are not utf-8 are base64 encoded, like values.
+type auditPath Path
+
+type auditName struct {
+	Base64 string `json:"base64"`
+}
+
+func (p auditPath) MarshalJSON() ([]byte, error) {
+	names := []any{}
+	for _, name := range p {
+		if utf8.Valid(name) {
+			names = append(names, string(name))
+		} else {
+			names = append(names, auditName{Base64: base64.StdEncoding.EncodeToString(name)})
+		}
+	}
+	return json.Marshal(names)
+}
+
+func (p *auditPath) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	// logs written before paths were lists hold them as / separated strings
+	legacy := ""
+	if json.Unmarshal(data, &legacy) == nil {
+		*p = auditPath(stringToPath(legacy))
+		return nil
+	}
+	names := []json.RawMessage{}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	path := auditPath{}
+	for _, raw := range names {
+		name := ""
+		if json.Unmarshal(raw, &name) == nil {
+			path = append(path, []byte(name))
+			continue
+		}
+		encoded := auditName{}
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return err
+		}
+		b, err := base64.StdEncoding.DecodeString(encoded.Base64)
+		if err != nil {
+			return err
+		}
+		path = append(path, b)
+	}
+	*p = path
+	return nil
+}
+
+type auditFilter struct {
+	Path string
+	From time.Time
+	To   time.Time
+}
+
+var (
+	auditMu sync.Mutex
+	// remoteMu is held by the server while a request runs, so changes are
+	// recorded with the remote user
+	remoteMu    sync.Mutex
+	auditRemote string
+	osUser      = sync.OnceValue(func() string {
+		u, err := user.Current()
+		if err != nil {
+			return os.Getenv("USER")
+		}
+		return u.Username
+	})
+)
+
+// the audit log is kept next to the database, like the schemas
+func auditFile() string {
+	return auditFileFor(dbFile)
+}
+
+func auditFileFor(file string) string {
+	return file + ".audit.jsonl"
+}
+
+// recordChange runs change in an update transaction and, once it commits,
+// records the item at from before and the item at to after the change; to is
+// nil for deletions.
+func recordChange(op string, from, to Path, change func(tx *bbolt.Tx) error) error {
+	return recordChanges(op, "", []Path{from}, []Path{to}, change)
+}
+
+func recordChanges(op, detail string, from, to []Path, change func(tx *bbolt.Tx) error) error {
+	changes := []txChange{}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		old := []*auditValue{}
+		for _, path := range from {
+			old = append(old, snapshotAt(path, tx))
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		for i, path := range from {
+			changes = append(changes, txChange{op: op, from: path, to: to[i], old: old[i], new: snapshotAt(to[i], tx)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeChanges(changes, detail)
+	return nil
+}
+
+// snapshotAt reads the item at path within tx; buckets are only recorded by
+// their sequence, so snapshots stay cheap for large buckets.
+func snapshotAt(path Path, tx *bbolt.Tx) *auditValue {
+	if len(path) == 0 {
+		return nil
+	}
+	if bucket, err := getBucket(path, tx); err == nil {
+		return &auditValue{Bucket: true, Sequence: bucket.Sequence()}
+	}
+	if len(path) < 2 { //nolint:mnd //keys are always inside a bucket
+		return nil
+	}
+	parent, err := getParentBucket(path, tx)
+	if err != nil {
+		return nil
+	}
+	value, ok := getValue(parent, path[len(path)-1])
+	if !ok {
+		return nil
+	}
+	return newAuditValue(TreeNode{Value: value})
+}
+
+func newAuditValue(node TreeNode) *auditValue {
+	if node.IsBucket {
+		return &auditValue{Bucket: true, Sequence: node.Sequence}
+	}
+	if len(node.Value) > auditValueLimit {
+		sum := sha256.Sum256(node.Value)
+		return &auditValue{Size: len(node.Value), SHA256: hex.EncodeToString(sum[:])}
+	}
+	return fullAuditValue(node.Value)
+}
+
+// fullAuditValue records value whatever its size, for backups.
+func fullAuditValue(value []byte) *auditValue {
+	sum := sha256.Sum256(value)
+	v := &auditValue{Size: len(value), SHA256: hex.EncodeToString(sum[:])}
+	if utf8.Valid(value) {
+		v.Value = string(value)
+	} else {
+		v.Value = base64.StdEncoding.EncodeToString(value)
+		v.Base64 = true
+	}
+	return v
+}
+
+// txChange is a change made within a transaction; changes are recorded once
+// the transaction commits.
+type txChange struct {
+	op       string
+	from, to Path
+	old, new *auditValue
+}
+
+// createdBuckets records buckets created within tx, such as missing parents.
+func createdBuckets(paths []Path, tx *bbolt.Tx) []txChange {
+	changes := []txChange{}
+	for _, path := range paths {
+		changes = append(changes, txChange{op: "create bucket", from: path, to: path, new: snapshotAt(path, tx)})
+	}
+	return changes
+}
+
+func writeChanges(changes []txChange, detail string) {
+	writeChangesTo(dbFile, changes, detail)
+	followChanges(dbFile, schemas, compiled, changes)
+}
+
+// writeChangesTo records changes to file, which need not be the current
+// database.
+func writeChangesTo(file string, changes []txChange, detail string) {
+	for _, change := range changes {
+		entry := auditEntry{
+			Database: file,
+			Op:       change.op,
+			Path:     auditPath(change.from),
+			Detail:   detail,
+			Old:      change.old,
+			New:      change.new,
+		}
+		if change.to != nil && !slices.EqualFunc(change.to, change.from, bytes.Equal) {
+			entry.To = auditPath(change.to)
+		}
+		writeAudit(entry)
+	}
+}
+
+// writeAudit appends entry to the audit log of its database, the current one
+// if not set; failing to write it is logged but does not undo the change.
+func writeAudit(entry auditEntry) {
+	entry.Time = time.Now()
+	entry.User = osUser()
+	entry.Remote = auditRemote
+	if entry.Database == "" {
+		entry.Database = dbFile
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("audit", err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(auditFileFor(entry.Database), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Println("audit", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Println("audit", err)
+	}
+}
+
+func (f auditFilter) match(entry auditEntry) bool {
+	if f.Path != "" && !strings.Contains(displayPath(Path(entry.Path)), f.Path) &&
+		!strings.Contains(displayPath(Path(entry.To)), f.Path) {
+		return false
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || entry.Time.Before(f.To)
+}
+
+// readAudit returns the matching entries of file, newest first, and the
+// number of lines that could not be read; lines may be of any length, as long
+// paths are recorded in full.
+func readAudit(file string, filter auditFilter) ([]auditEntry, int, error) {
+	entries := []auditEntry{}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	bad := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, bad, err
+		}
+		if line == "" {
+			continue
+		}
+		entry := auditEntry{}
+		switch {
+		case json.Unmarshal([]byte(line), &entry) != nil:
+			bad++
+		case filter.match(entry):
+			entries = append(entries, entry)
+		}
+	}
+	slices.Reverse(entries)
+	return entries, bad, nil
+}
+
+// readLine reads a line of any length; it returns io.EOF once nothing is left.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), err
+}
+
+// parseAuditTime accepts a date, a date and time, or RFC3339, in local time.
+func parseAuditTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use 2006-01-02 15:04", s)
+}
+
+func (v *auditValue) String() string {
+	switch {
+	case v == nil:
+		return "-"
+	case v.Bucket:
+		return fmt.Sprintf("bucket sequence %d", v.Sequence)
+	case v.Value == "" && v.Size > 0:
+		return fmt.Sprintf("%d bytes sha256:%.12s", v.Size, v.SHA256)
+	case v.Base64:
+		return "base64:" + preview([]byte(v.Value))
+	}
+	return preview([]byte(v.Value))
+}
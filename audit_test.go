@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// entries are read back whatever their length
+func TestAuditLongPath(t *testing.T) {
+	openTestDB(t)
+	path := Path{bytes.Repeat([]byte("b"), 200<<10)}
+	if _, err := CreateBucket(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateKey([]byte("k"), bytes.Repeat([]byte{0xff}, auditValueLimit), path); err != nil {
+		t.Fatal(err)
+	}
+	entries, bad, err := readAudit(auditFile(), auditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad != 0 || len(entries) != 2 {
+		t.Fatalf("%d entries, %d unreadable", len(entries), bad)
+	}
+	if !slices.EqualFunc(Path(entries[1].Path), path, bytes.Equal) {
+		t.Errorf("path of %d bytes read back", len(entries[1].Path[0]))
+	}
+}
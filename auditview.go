@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/text/textcore"
+)
+
+type auditRow struct {
+	Time  string
+	User  string
+	Op    string
+	Path  string
+	Old   string
+	New   string
+	entry auditEntry
+}
+
+func newAuditRow(entry auditEntry) auditRow {
+	row := auditRow{
+		Time:  entry.Time.Local().Format("2006-01-02 15:04:05"),
+		User:  entry.User,
+		Op:    entry.Op,
+		Path:  displayPath(Path(entry.Path)),
+		Old:   entry.Old.String(),
+		New:   entry.New.String(),
+		entry: entry,
+	}
+	if entry.Remote != "" {
+		row.User = entry.Remote + " (server)"
+	}
+	if entry.To != nil {
+		row.Path += " → " + displayPath(Path(entry.To))
+	}
+	if entry.Error != "" {
+		row.Op += " (" + entry.Error + ")"
+	}
+	return row
+}
+
+// auditDialog shows the audit log of the current database, filtered to
+// items whose path contains path.
+func auditDialog(path string, button *core.Button) {
+	rows := []auditRow{}
+	title := "Audit Log"
+	s := current
+	d := core.NewBody(title)
+	core.NewText(d).SetText(auditFile())
+	bar := core.NewFrame(d)
+	core.NewText(bar).SetText("Path")
+	pathField := core.NewTextField(bar).SetText(path)
+	core.NewText(bar).SetText("From")
+	from := core.NewTextField(bar).SetPlaceholder("2006-01-02 15:04")
+	core.NewText(bar).SetText("To")
+	to := core.NewTextField(bar).SetPlaceholder("2006-01-02 15:04")
+	count := core.NewText(d)
+	table := core.NewTable(d)
+	table.SetReadOnly(true)
+	table.SetSlice(&rows)
+	details := textcore.NewEditor(d)
+	details.SetReadOnly(true)
+	load := func() {
+		filter := auditFilter{Path: pathField.Text()}
+		var err error
+		if filter.From, err = parseAuditTime(from.Text()); err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		if filter.To, err = parseAuditTime(to.Text()); err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		bad := 0
+		err = withSession(s, func() error {
+			entries, skipped, err := readAudit(auditFile(), filter)
+			if err != nil {
+				return err
+			}
+			bad = skipped
+			rows = rows[:0]
+			for _, entry := range entries {
+				rows = append(rows, newAuditRow(entry))
+			}
+			return nil
+		})
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		text := strconv.Itoa(len(rows)) + " changes"
+		if bad > 0 {
+			text += ", " + strconv.Itoa(bad) + " unreadable lines skipped"
+		}
+		count.SetText(text).Update()
+		details.Lines.SetText(nil)
+		table.Update()
+	}
+	for _, field := range []*core.TextField{pathField, from, to} {
+		field.OnChange(func(e events.Event) {
+			load()
+		})
+	}
+	core.NewButton(bar).SetText("Reload").OnClick(func(e events.Event) {
+		load()
+	})
+	save := core.NewButton(bar).SetText("Save Script")
+	save.OnClick(func(e events.Event) {
+		entries := []auditEntry{}
+		for _, row := range slices.Backward(rows) {
+			entries = append(entries, row.entry)
+		}
+		if err := withSession(s, func() error {
+			saveScriptDialog(entries, save)
+			return nil
+		}); err != nil {
+			core.ErrorDialog(d, err, title)
+		}
+	})
+	replay := core.NewButton(bar).SetText("Replay Script")
+	replay.OnClick(func(e events.Event) {
+		replayDialog(s, replay)
+	})
+	table.OnSelect(func(e events.Event) {
+		if table.SelectedIndex < 0 || table.SelectedIndex >= len(rows) {
+			return
+		}
+		data, err := json.MarshalIndent(rows[table.SelectedIndex].entry, "", "  ")
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		details.Lines.SetText(data)
+	})
+	load()
+	d.RunWindowDialog(button)
+}
+
+// saveScriptDialog saves the listed changes, oldest first, as a script that
+// can be replayed on another copy of the database.
+func saveScriptDialog(entries []auditEntry, button *core.Button) {
+	title := "Save Script"
+	d := core.NewBody(title)
+	core.NewText(d).SetText(strconv.Itoa(len(entries)) + " changes")
+	file := core.NewTextField(d).SetText(filepath.Join(filepath.Dir(dbFile), "changes.jsonl"))
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			if err := writeScript(file.Text(), entries); err != nil {
+				core.ErrorDialog(button, err, title)
+				return
+			}
+			core.MessageSnackbar(button, "saved "+file.Text())
+		})
+	})
+	d.RunDialog(button)
+}
+
+type replayRow struct {
+	Line   int
+	Op     string
+	Path   string
+	Status string
+}
+
+// replayDialog applies scripts to the database of s.
+func replayDialog(s *session, button *core.Button) {
+	rows := []replayRow{}
+	title := "Replay Script"
+	d := core.NewBody(title)
+	core.NewText(d).SetText("Apply a change script to " + s.fileName() + "; every step is checked against the database first and nothing is changed unless all steps succeed")
+	script := core.NewFileButton(d).SetExtensions(".jsonl")
+	status := core.NewText(d)
+	table := core.NewTable(d)
+	table.SetReadOnly(true)
+	table.SetSlice(&rows)
+	run := func(dryRun bool) {
+		f, err := os.Open(script.Filename)
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		defer f.Close()
+		steps, err := readScript(f)
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		var results []replayResult
+		err = withSession(s, func() error {
+			var err error
+			results, err = Replay(steps, dryRun)
+			if err == nil && !dryRun {
+				reload()
+			}
+			return err
+		})
+		rows = rows[:0]
+		for _, result := range results {
+			rows = append(rows, replayRow{Line: result.Line, Op: result.Op, Path: result.Path, Status: result.Status})
+		}
+		switch {
+		case err != nil:
+			status.SetText("nothing was changed: " + err.Error())
+		case dryRun:
+			status.SetText(strconv.Itoa(len(rows)) + " steps can be applied")
+		default:
+			status.SetText(strconv.Itoa(len(rows)) + " steps applied")
+		}
+		status.Update()
+		table.Update()
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar).SetText("Close")
+		core.NewButton(bar).SetText("Dry Run").OnClick(func(e events.Event) {
+			run(true)
+		})
+		core.NewButton(bar).SetText("Replay").OnClick(func(e events.Event) {
+			run(false)
+		})
+	})
+	d.RunWindowDialog(button)
+}
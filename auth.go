@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errUnauthorized = errors.New("authentication required")
+	errForbidden    = errors.New("permission denied")
+	errUsersFile    = errors.New("invalid users file")
+)
+
+// apiUser is an entry of the users file. Passwords are stored as bcrypt
+// hashes, e.g. from `htpasswd -nbBC 12 "" secret | tr -d ':\n'`; tokens,
+// which are long random strings rather than something chosen by a person,
+// as hex encoded sha256 hashes, e.g. from `printf %s token | sha256sum`.
+type apiUser struct {
+	Name           string   `json:"name"`
+	PasswordBcrypt string   `json:"password_bcrypt,omitempty"`
+	PasswordSHA256 string   `json:"password_sha256,omitempty"`
+	TokenSHA256    string   `json:"token_sha256,omitempty"`
+	Write          bool     `json:"write"`
+	Prefixes       []string `json:"prefixes,omitempty"`
+	password       []byte
+	token          []byte
+	prefixes       []Path
+}
+
+// noUser is compared against for unknown user names, so they take as long
+// to reject as a wrong password.
+var noUser, _ = bcrypt.GenerateFromPassword([]byte("no user"), bcrypt.MinCost)
+
+type userKey struct{}
+
+func loadUsers(file string) ([]*apiUser, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	users := []*apiUser{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("%w: %w", errUsersFile, err)
+	}
+	for _, user := range users {
+		if user.Name == "" {
+			return nil, fmt.Errorf("%w: user without a name", errUsersFile)
+		}
+		if user.PasswordSHA256 != "" {
+			return nil, fmt.Errorf("%w: %s: password_sha256 is no longer accepted, use password_bcrypt", errUsersFile,
+				user.Name)
+		}
+		if user.PasswordBcrypt != "" {
+			user.password = []byte(user.PasswordBcrypt)
+			if _, err := bcrypt.Cost(user.password); err != nil {
+				return nil, fmt.Errorf("%w: %s password: %w", errUsersFile, user.Name, err)
+			}
+		}
+		if user.token, err = decodeSecret(user.TokenSHA256); err != nil {
+			return nil, fmt.Errorf("%w: %s token: %w", errUsersFile, user.Name, err)
+		}
+		if user.password == nil && user.token == nil {
+			return nil, fmt.Errorf("%w: %s has neither a password nor a token", errUsersFile, user.Name)
+		}
+		for _, prefix := range user.Prefixes {
+			path, err := unescapePath(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s prefix %q: %w", errUsersFile, user.Name, prefix, err)
+			}
+			user.prefixes = append(user.prefixes, path)
+		}
+	}
+	return users, nil
+}
+
+func decodeSecret(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	secret, err := hex.DecodeString(s)
+	if err == nil && len(secret) != sha256.Size {
+		err = errors.New("not a sha256 hash")
+	}
+	return secret, err
+}
+
+func matchToken(hash []byte, token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	return hash != nil && subtle.ConstantTimeCompare(hash, sum[:]) == 1
+}
+
+func matchPassword(hash []byte, password string) bool {
+	if hash == nil {
+		bcrypt.CompareHashAndPassword(noUser, []byte(password)) //nolint:errcheck,gosec //only for the time taken
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// authenticate requires basic auth or a bearer token from one of the users;
+// without users every request is let through.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	if s.users == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := s.findUser(r)
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bboltEditor"`)
+			writeError(w, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+func (s *server) findUser(r *http.Request) *apiUser {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, user := range s.users {
+			if matchToken(user.token, token) {
+				return user
+			}
+		}
+		return nil
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	for _, user := range s.users {
+		if user.Name == name {
+			if matchPassword(user.password, password) {
+				return user
+			}
+			return nil
+		}
+	}
+	matchPassword(nil, password)
+	return nil
+}
+
+func requestUser(r *http.Request) *apiUser {
+	user, _ := r.Context().Value(userKey{}).(*apiUser)
+	return user
+}
+
+func userName(r *http.Request) string {
+	if user := requestUser(r); user != nil {
+		return user.Name
+	}
+	return "-"
+}
+
+// allowed reports whether the user may read or write path. Users limited to
+// prefixes only have access to the prefixes and what is inside them.
+func (u *apiUser) allowed(path Path, write bool) bool {
+	if u == nil {
+		return true
+	}
+	if write && !u.Write {
+		return false
+	}
+	if len(u.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range u.prefixes {
+		if slices.EqualFunc(prefix, path, bytes.Equal) || isInside(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible reports whether the user may see the name of path: anything the
+// user may read, and the buckets leading to the user's prefixes, so they can
+// be browsed to.
+func (u *apiUser) visible(path Path) bool {
+	if u.allowed(path, false) {
+		return true
+	}
+	for _, prefix := range u.prefixes {
+		if isInside(prefix, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func authorize(r *http.Request, write bool, paths ...Path) error {
+	user := requestUser(r)
+	for _, path := range paths {
+		if !user.allowed(path, write) {
+			return fmt.Errorf("%w: %s", errForbidden, displayPath(path))
+		}
+	}
+	return nil
+}
+
+// audited runs a change for the request's user; the change is recorded in
+// the audit log by the database operations, denied and failed requests are
+// recorded here.
+func audited(r *http.Request, op string, from, to Path, change func() error) error {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	auditRemote = userName(r)
+	defer func() {
+		auditRemote = ""
+	}()
+	err := change()
+	if err != nil {
+		if errors.Is(err, errForbidden) {
+			log.Println("denied", auditRemote, op, pathToString(from), err)
+		} else {
+			log.Println("failed", auditRemote, op, pathToString(from), err)
+		}
+		writeAudit(auditEntry{Op: op, Path: auditPath(from), To: auditPath(to), Error: err.Error()})
+	}
+	return err
+}
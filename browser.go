@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+)
+
+const browsePageSize = 100
+
+func browseDialog(node TreeNode, button *core.Button) {
+	rows := []browseRow{}
+	title := "Browse " + displayPath(node.Path)
+	d := core.NewBody(title)
+	bar := core.NewFrame(d)
+	core.NewText(bar).SetText("Prefix")
+	prefix := core.NewTextField(bar)
+	core.NewText(bar).SetText("Seek")
+	seek := core.NewTextField(bar)
+	table := core.NewTable(d)
+	table.SetReadOnly(true)
+	table.SetSlice(&rows)
+	load := func(key []byte, dir browseDirection) {
+		keyPrefix := []byte{}
+		if prefix.Text() != "" {
+			var err error
+			if keyPrefix, err = parseName(node.Path, prefix.Text()); err != nil {
+				core.ErrorDialog(d, err, title)
+				return
+			}
+		}
+		page, err := BrowseKeys(node.Path, keyPrefix, key, dir, browsePageSize)
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		if len(page) == 0 && dir != browseFrom {
+			core.MessageSnackbar(d, "no more keys")
+			return
+		}
+		rows = page
+		table.Update()
+	}
+	core.NewButton(bar).SetText("Seek").OnClick(func(e events.Event) {
+		key, err := parseName(node.Path, seek.Text())
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		load(key, browseFrom)
+	})
+	core.NewButton(bar).SetText("Previous").OnClick(func(e events.Event) {
+		if len(rows) > 0 {
+			load(rows[0].key, browseBefore)
+		}
+	})
+	core.NewButton(bar).SetText("Next").OnClick(func(e events.Event) {
+		if len(rows) > 0 {
+			load(rows[len(rows)-1].key, browseAfter)
+		}
+	})
+	prefix.OnChange(func(e events.Event) {
+		load(nil, browseFrom)
+	})
+	table.OnSelect(func(e events.Event) {
+		if table.SelectedIndex < 0 || table.SelectedIndex >= len(rows) {
+			return
+		}
+		name := pathToString(append(slices.Clone(node.Path), rows[table.SelectedIndex].key))
+		if _, ok := nodeMap[name]; ok {
+			updateDetails(name)
+		}
+	})
+	load(nil, browseFrom)
+	d.RunWindowDialog(button)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"go.etcd.io/bbolt"
+)
+
+var errKeyAtRoot = errors.New("keys cannot be stored at the top level")
+
+type bulkSummary struct {
+	Keys    int
+	Buckets int
+}
+
+func (s bulkSummary) String() string {
+	return fmt.Sprintf("%d keys and %d buckets", s.Keys, s.Buckets)
+}
+
+func (s *bulkSummary) add(node TreeNode) {
+	if node.IsBucket {
+		s.Buckets++
+	} else {
+		s.Keys++
+	}
+}
+
+type exportEntry struct {
+	Path     Path   `json:"path"`
+	Bucket   bool   `json:"bucket,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	Value    []byte `json:"value,omitempty"`
+}
+
+// topLevelItems drops items that are inside another selected bucket, as they
+// are handled together with that bucket.
+func topLevelItems(nodes []TreeNode) []TreeNode {
+	items := []TreeNode{}
+	for _, node := range nodes {
+		nested := slices.ContainsFunc(nodes, func(other TreeNode) bool {
+			return other.IsBucket && isInside(node.Path, other.Path)
+		})
+		if !nested {
+			items = append(items, node)
+		}
+	}
+	return items
+}
+
+func DeleteItems(nodes []TreeNode) (bulkSummary, error) {
+	summary := bulkSummary{}
+	items := topLevelItems(nodes)
+	from := []Path{}
+	for _, node := range items {
+		from = append(from, node.Path)
+	}
+	err := recordChanges("delete", "", from, make([]Path, len(from)), func(tx *bbolt.Tx) error {
+		for _, node := range items {
+			err := checkNode(node, tx)
+			if err == nil && node.IsBucket {
+				err = deleteBucket(node.Path, tx)
+			} else if err == nil {
+				err = deleteKey(node.Path, tx)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", pathToString(node.Path), err)
+			}
+			summary.add(node)
+		}
+		return nil
+	})
+	if err != nil {
+		return bulkSummary{}, err
+	}
+	log.Println("deleted", summary)
+	return summary, nil
+}
+
+func MoveItems(nodes []TreeNode, dest Path) (bulkSummary, error) {
+	return transferItems(nodes, dest, true)
+}
+
+func CopyItems(nodes []TreeNode, dest Path) (bulkSummary, error) {
+	return transferItems(nodes, dest, false)
+}
+
+func transferItems(nodes []TreeNode, dest Path, move bool) (bulkSummary, error) {
+	summary := bulkSummary{}
+	items := topLevelItems(nodes)
+	from, to := []Path{}, []Path{}
+	for _, node := range items {
+		from = append(from, node.Path)
+		to = append(to, append(slices.Clone(dest), node.Name))
+	}
+	op := "copy"
+	if move {
+		op = "move"
+	}
+	err := recordChanges(op, "", from, to, func(tx *bbolt.Tx) error {
+		for i, node := range items {
+			if err := transferItem(node, to[i], move, tx); err != nil {
+				return fmt.Errorf("%s: %w", pathToString(node.Path), err)
+			}
+			summary.add(node)
+		}
+		return nil
+	})
+	if err != nil {
+		return bulkSummary{}, err
+	}
+	log.Println("transferred", summary, "to", pathToString(dest))
+	return summary, nil
+}
+
+func transferItem(node TreeNode, newPath Path, move bool, tx *bbolt.Tx) error {
+	if err := checkNode(node, tx); err != nil {
+		return err
+	}
+	if !node.IsBucket {
+		if len(newPath) < 2 { //nolint:mnd //keys are always inside a bucket
+			return errKeyAtRoot
+		}
+		if move {
+			return moveKey(node.Path, newPath, tx)
+		}
+		return copyKey(node.Path, newPath, tx)
+	}
+	if move {
+		return moveBucket(node.Path, newPath, tx)
+	}
+	if isInside(newPath, node.Path) {
+		return errInsideSource
+	}
+	bucket, err := getBucket(node.Path, tx)
+	if err != nil {
+		return err
+	}
+	return copyBucket(bucket, newPath, tx)
+}
+
+func ExportItems(nodes []TreeNode) ([]byte, bulkSummary, error) {
+	summary := bulkSummary{}
+	entries := []exportEntry{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		for _, node := range topLevelItems(nodes) {
+			if !node.IsBucket {
+				parent, err := getParentBucket(node.Path, tx)
+				if err != nil {
+					return err
+				}
+				value := parent.Get(node.Name)
+				if value == nil {
+					return fmt.Errorf("%s: %w", pathToString(node.Path), errInvalidPath)
+				}
+				entries = append(entries, exportEntry{Path: node.Path, Value: slices.Clone(value)})
+				summary.add(node)
+				continue
+			}
+			bucket, err := getBucket(node.Path, tx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pathToString(node.Path), err)
+			}
+			entries = exportBucket(bucket, slices.Clone(node.Path), entries)
+			summary.add(node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, bulkSummary{}, err
+	}
+	data, err := json.MarshalIndent(entries, "", "\t")
+	return data, summary, err
+}
+
+func exportBucket(bucket *bbolt.Bucket, path Path, entries []exportEntry) []exportEntry {
+	entries = append(entries, exportEntry{Path: path, Bucket: true, Sequence: bucket.Sequence()})
+	bucket.ForEach(func(k, v []byte) error { //nolint:gosec // no errors returned
+		child := append(slices.Clone(path), slices.Clone(k))
+		if v == nil {
+			entries = exportBucket(bucket.Bucket(k), child, entries)
+			return nil
+		}
+		entries = append(entries, exportEntry{Path: child, Value: slices.Clone(v)})
+		return nil
+	})
+	return entries
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"delete-range": {
+		usage: "delete-range [-prefix p | -start s [-end e]] [-dry-run] [-chunk n] dbfile bucket/path",
+		run:   deleteRangeCommand,
+	},
+	"dump": {
+		usage: "dump dbfile [dumpfile]",
+		run:   dumpCommand,
+	},
+	"patch": {
+		usage: "patch [-merge] [-dry-run] dbfile bucket/path[/key] patch.json|-",
+		run:   patchCommand,
+	},
+	"replay": {
+		usage: "replay [-dry-run] dbfile script.jsonl",
+		run:   replayCommand,
+	},
+	"restore": {
+		usage: "restore dumpfile|- dbfile",
+		run:   restoreCommand,
+	},
+	"script": {
+		usage: "script [-dry-run] dbfile script.star",
+		run:   scriptCommand,
+	},
+	"serve": {
+		usage: "serve [-addr host:port] [-read-only] [-users file] [-tls-cert file -tls-key file] dbfile",
+		run:   serveCommand,
+	},
+	"transform": {
+		usage: "transform [-dry-run] [-chunk n] [-backup file] [-show n] dbfile bucket/path template",
+		run:   transformCommand,
+	},
+	"web": {
+		usage: "web [-addr host:port] [-read-only] [-users file] [-tls-cert file -tls-key file] dbfile",
+		run:   webCommand,
+	},
+}
+
+// runCommand runs a command line subcommand; ok is false when args do not
+// name one, in which case the editor is started.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false, nil
+	}
+	if err := cmd.run(args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "usage: bboltEditor", cmd.usage)
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+func deleteRangeCommand(args []string) error {
+	flags := flag.NewFlagSet("delete-range", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "delete keys with this prefix")
+	start := flags.String("start", "", "delete keys from this key (inclusive)")
+	end := flags.String("end", "", "delete keys up to this key (exclusive)")
+	dryRun := flags.Bool("dry-run", false, "only count matching keys")
+	chunk := flags.Int("chunk", copyChunkSize, "keys deleted per transaction")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 { //nolint:mnd //dbfile and bucket
+		return errUsage
+	}
+	if err := openDB(flags.Arg(0)); err != nil {
+		return err
+	}
+	defer closeDB()
+	path := stringToPath(flags.Arg(1))
+	r := keyRange{Prefix: []byte(*prefix), Start: []byte(*start), End: []byte(*end)}
+	count, err := CountRange(path, r)
+	if err != nil {
+		return err
+	}
+	fmt.Println(count, "keys match")
+	if *dryRun {
+		return nil
+	}
+	deleted, err := DeleteRange(path, r, *chunk)
+	fmt.Println(deleted, "keys deleted")
+	return err
+}
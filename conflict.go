@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errConflict      = errors.New("changed since it was loaded")
+	errMergeConflict = errors.New("changed on both sides")
+	errCannotMerge   = errors.New("only json values can be merged")
+)
+
+type conflictError struct {
+	Path    Path
+	Deleted bool
+}
+
+func (e *conflictError) Error() string {
+	if e.Deleted {
+		return displayPath(e.Path) + ": deleted since it was loaded"
+	}
+	return displayPath(e.Path) + ": " + errConflict.Error()
+}
+
+func (e *conflictError) Unwrap() error {
+	return errConflict
+}
+
+// bucketVersion identifies the state of a bucket by hashing its sequence
+// and every entry in it, nested buckets by their own version, so any change
+// within the bucket gives a new version. Root page ids cannot be used, as
+// bbolt reuses freed pages.
+func bucketVersion(b *bbolt.Bucket) []byte {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, b.Sequence()))
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		kind := byte('k')
+		if v == nil {
+			kind = 'b'
+			v = bucketVersion(b.Bucket(k))
+		}
+		h.Write([]byte{kind})
+		hashEntry(h, k, v)
+	}
+	return h.Sum(nil)
+}
+
+func hashEntry(h hash.Hash, k, v []byte) {
+	for _, b := range [][]byte{k, v} {
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(b)))) //nolint:gosec //length is never negative
+		h.Write(b)
+	}
+}
+
+// checkNode fails with a conflictError if the item no longer matches the
+// snapshot in node. Buckets without a version were never loaded and are not
+// checked.
+func checkNode(node TreeNode, tx *bbolt.Tx) error {
+	if node.IsBucket {
+		if node.Version == nil {
+			return nil
+		}
+		bucket, err := getBucket(node.Path, tx)
+		if err != nil {
+			return &conflictError{Path: node.Path, Deleted: true}
+		}
+		if !bytes.Equal(bucketVersion(bucket), node.Version) {
+			return &conflictError{Path: node.Path}
+		}
+		return nil
+	}
+	parent, err := getParentBucket(node.Path, tx)
+	if err != nil || parent == nil {
+		return &conflictError{Path: node.Path, Deleted: true}
+	}
+	value, ok := getValue(parent, node.Name)
+	if !ok {
+		return &conflictError{Path: node.Path, Deleted: true}
+	}
+	if !bytes.Equal(value, node.Value) {
+		return &conflictError{Path: node.Path}
+	}
+	return nil
+}
+
+// checkLoaded fails with a conflictError if a bucket along path that was
+// loaded into the tree has been deleted since; buckets that were not loaded
+// are new and are not checked.
+func checkLoaded(path Path, tx *bbolt.Tx) error {
+	for i := range path {
+		node, ok := nodeMap[pathToString(path[:i+1])]
+		if !ok || !node.IsBucket {
+			continue
+		}
+		if _, err := getBucket(path[:i+1], tx); err != nil {
+			return &conflictError{Path: path[:i+1], Deleted: true}
+		}
+	}
+	return nil
+}
+
+// currentNode reads the item at path as it is now.
+func currentNode(path Path) (TreeNode, error) {
+	node := TreeNode{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		node, err = nodeAt(path, tx)
+		return err
+	})
+	return node, err
+}
+
+func nodeAt(path Path, tx *bbolt.Tx) (TreeNode, error) {
+	if bucket, err := getBucket(path, tx); err == nil {
+		return *process(path[len(path)-1], parentPath(path), bucket)[0], nil
+	}
+	parent, err := getParentBucket(path, tx)
+	if err != nil || parent == nil {
+		return TreeNode{}, errInvalidPath
+	}
+	value, ok := getValue(parent, path[len(path)-1])
+	if !ok {
+		return TreeNode{}, errInvalidPath
+	}
+	return TreeNode{
+		Path:  slices.Clone(path),
+		Name:  bytes.Clone(path[len(path)-1]),
+		Value: bytes.Clone(value),
+	}, nil
+}
+
+func currentNodes(nodes []TreeNode) ([]TreeNode, error) {
+	current := []TreeNode{}
+	for _, node := range nodes {
+		fresh, err := currentNode(node.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", displayPath(node.Path), err)
+		}
+		current = append(current, fresh)
+	}
+	return current, nil
+}
+
+// mergeJSON applies the changes from base to ours on top of theirs, failing
+// where both sides changed the same field differently.
+func mergeJSON(base, ours, theirs []byte) ([]byte, error) {
+	values := []*jsonValue{}
+	for _, data := range [][]byte{base, ours, theirs} {
+		value, err := parseJSON(data)
+		if err != nil {
+			return nil, errCannotMerge
+		}
+		values = append(values, value)
+	}
+	merged, err := merge3("", values[0], values[1], values[2])
+	if err != nil {
+		return nil, err
+	}
+	return merged.marshal(), nil
+}
+
+func sameJSON(a, b *jsonValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Kind == b.Kind && bytes.Equal(a.marshal(), b.marshal())
+}
+
+func merge3(path string, base, ours, theirs *jsonValue) (*jsonValue, error) {
+	switch {
+	case sameJSON(ours, base) || sameJSON(ours, theirs):
+		return theirs, nil
+	case sameJSON(theirs, base):
+		return ours, nil
+	case base == nil || base.Kind != jsonObject || ours.Kind != jsonObject || theirs.Kind != jsonObject:
+		return nil, fmt.Errorf("%s: %w", jsonPath(path), errMergeConflict)
+	}
+	merged := &jsonValue{Kind: jsonObject, Key: theirs.Key}
+	add := func(key string) error {
+		o, t := ours.child(key), theirs.child(key)
+		b := base.child(key)
+		var value *jsonValue
+		switch {
+		case o != nil && t != nil:
+			var err error
+			if value, err = merge3(path+"/"+key, b, o, t); err != nil {
+				return err
+			}
+		case b == nil:
+			// added on one side only
+			value = o
+			if value == nil {
+				value = t
+			}
+		case o == nil && sameJSON(t, b), t == nil && sameJSON(o, b):
+			// deleted on one side and unchanged on the other
+			return nil
+		default:
+			return fmt.Errorf("%s/%s: %w", path, key, errMergeConflict)
+		}
+		merged.Children = append(merged.Children, value)
+		return nil
+	}
+	// keep their field order, with our new fields at the end
+	for _, t := range theirs.Children {
+		if err := add(t.Key); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range ours.Children {
+		if theirs.hasKey(o.Key) {
+			continue
+		}
+		if err := add(o.Key); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// a change anywhere within a bucket changes its version, however the pages
+// were reused
+func TestBucketVersion(t *testing.T) {
+	openTestDB(t)
+	outer, inner := Path{[]byte("a")}, Path{[]byte("a"), []byte("b")}
+	if _, err := CreateBucket(inner); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateKey([]byte("k"), []byte("1"), inner); err != nil {
+		t.Fatal(err)
+	}
+	version := func() []byte {
+		t.Helper()
+		var v []byte
+		if err := db.View(func(tx *bbolt.Tx) error {
+			b, err := getBucket(outer, tx)
+			v = bucketVersion(b)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	before := version()
+	node, err := currentNode(Path{[]byte("a"), []byte("b"), []byte("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateKey(node, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	changed := version()
+	if bytes.Equal(before, changed) {
+		t.Error("nested change kept the version")
+	}
+	if _, err := CreateBucket(Path{[]byte("other")}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(changed, version()) {
+		t.Error("change elsewhere changed the version")
+	}
+}
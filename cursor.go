@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"unicode"
+
+	"go.etcd.io/bbolt"
+)
+
+const previewLength = 80
+
+type browseDirection int
+
+const (
+	browseFrom browseDirection = iota
+	browseAfter
+	browseBefore
+)
+
+type browseRow struct {
+	Key     string
+	Size    int
+	Preview string
+	key     []byte
+	bucket  bool
+}
+
+func newBrowseRow(k, v []byte, format keyFormat) browseRow {
+	row := browseRow{Key: format.encode(k), key: bytes.Clone(k)}
+	if v == nil {
+		row.bucket = true
+		row.Preview = "(bucket)"
+		return row
+	}
+	row.Size = len(v)
+	row.Preview = preview(v)
+	return row
+}
+
+func preview(v []byte) string {
+	if len(v) > previewLength {
+		v = v[:previewLength]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return '.'
+		}
+		return r
+	}, string(v))
+}
+
+// BrowseKeys reads one page of a bucket with a cursor. Depending on dir the
+// page starts at key, starts after key or ends before key; only keys with
+// prefix are returned.
+func BrowseKeys(path Path, prefix, key []byte, dir browseDirection, size int) ([]browseRow, error) {
+	rows := []browseRow{}
+	format := formatFor(path)
+	if bytes.Compare(key, prefix) < 0 {
+		key = prefix
+	}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		c := bucket.Cursor()
+		if dir == browseBefore {
+			k, v := c.Seek(key)
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+			for ; k != nil && bytes.HasPrefix(k, prefix) && len(rows) < size; k, v = c.Prev() {
+				rows = append(rows, newBrowseRow(k, v, format))
+			}
+			slices.Reverse(rows)
+			return nil
+		}
+		k, v := c.Seek(key)
+		if dir == browseAfter && bytes.Equal(k, key) {
+			k, v = c.Next()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(rows) < size; k, v = c.Next() {
+			rows = append(rows, newBrowseRow(k, v, format))
+		}
+		return nil
+	})
+	return rows, err
+}
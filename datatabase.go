@@ -1,32 +1,45 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
 	"go.etcd.io/bbolt"
 )
 
+const copyChunkSize = 1000
+
 var (
-	db             *bbolt.DB
-	dbFile         string
-	errInvalidPath = errors.New("invalid path")
-	errKeyExists   = errors.New("key exists")
-	nodeMap        = make(map[string]TreeNode)
+	db              *bbolt.DB
+	dbFile          string
+	errInvalidPath  = errors.New("invalid path")
+	errKeyExists    = errors.New("key exists")
+	errBucketExists = errors.New("destination bucket already exists")
+	errInsideSource = errors.New("destination is inside source bucket")
+	nodeMap         = make(map[string]TreeNode)
 )
 
 func openDB(file string) error {
+	return openDBWith(file, &bbolt.Options{Timeout: time.Second})
+}
+
+func openDBWith(file string, options *bbolt.Options) error {
 	var err error
 	if db != nil {
 		closeDB()
 	}
-	db, err = bbolt.Open(file, 0o666, &bbolt.Options{Timeout: time.Second})
+	db, err = bbolt.Open(file, 0o666, options)
 	if err != nil {
 		return err
 	}
 	dbFile = file
+	if err := loadSchemas(); err != nil {
+		log.Println("load schemas", err)
+	}
 	return nil
 }
 
@@ -60,25 +73,53 @@ func getNodes() []*TreeNode {
 		})
 		return nil
 	})
+	nodeMap = make(map[string]TreeNode)
 	mapNodes(allNodes)
 	return allNodes
 }
 
+// bucketPaths lists every bucket of database, parents before their
+// children, without reading any values.
+func bucketPaths(database *bbolt.DB) []Path {
+	paths := []Path{}
+	if database == nil {
+		return paths
+	}
+	var walk func(path Path, b *bbolt.Bucket) error
+	walk = func(path Path, b *bbolt.Bucket) error {
+		paths = append(paths, path)
+		return b.ForEachBucket(func(k []byte) error {
+			return walk(childPath(path, k), b.Bucket(k))
+		})
+	}
+	database.View(func(tx *bbolt.Tx) error { //nolint:errcheck,gosec // no errors returned
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			return walk(Path{bytes.Clone(name)}, b)
+		})
+	})
+	return paths
+}
+
 func process(name []byte, path Path, b *bbolt.Bucket) []*TreeNode {
 	nodes := []*TreeNode{}
-	path = append(path, name)
+	// keys and values are only valid for the life of the transaction
+	name = bytes.Clone(name)
+	path = append(slices.Clone(path), name)
 	node := &TreeNode{
 		Path:     path,
 		Name:     name,
 		IsBucket: true,
+		Sequence: b.Sequence(),
+		Version:  bucketVersion(b),
 	}
 	b.ForEach(func(k, v []byte) error { //nolint:gosec // no errors returned
 		if v != nil {
+			k = bytes.Clone(k)
 			child := &TreeNode{
-				Path:     append(path, k),
+				Path:     append(slices.Clone(path), k),
 				IsBucket: false,
 				Name:     k,
-				Value:    v,
+				Value:    bytes.Clone(v),
 			}
 			node.Children = append(node.Children, child)
 		} else {
@@ -101,21 +142,54 @@ func CreateBucket(path Path) (*bbolt.Bucket, error) {
 	if path == nil {
 		return nil, errInvalidPath
 	}
+	changes := []txChange{}
 	err := db.Update(func(tx *bbolt.Tx) error {
+		if err := checkLoaded(parentPath(path), tx); err != nil {
+			return err
+		}
+		var created []Path
 		var err error
-		bucket, err = tx.CreateBucketIfNotExists(path[0])
+		bucket, created, err = createMissing(path, tx)
 		if err != nil {
 			return err
 		}
-		for _, p := range path[1:] {
-			bucket, err = bucket.CreateBucketIfNotExists(p)
+		changes = createdBuckets(created, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeChanges(changes, "")
+	return bucket, nil
+}
+
+// createMissing creates the buckets of path which do not exist yet and
+// returns their paths, parents first, so each creation can be recorded.
+func createMissing(path Path, tx *bbolt.Tx) (*bbolt.Bucket, []Path, error) {
+	created := []Path{}
+	var bucket *bbolt.Bucket
+	for i, name := range path {
+		var next *bbolt.Bucket
+		if bucket == nil {
+			next = tx.Bucket(name)
+		} else {
+			next = bucket.Bucket(name)
+		}
+		if next == nil {
+			var err error
+			if bucket == nil {
+				next, err = tx.CreateBucket(name)
+			} else {
+				next, err = bucket.CreateBucket(name)
+			}
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
+			created = append(created, slices.Clone(path[:i+1]))
 		}
-		return nil
-	})
-	return bucket, err
+		bucket = next
+	}
+	return bucket, created, nil
 }
 
 func createBucket(path Path, tx *bbolt.Tx) (*bbolt.Bucket, error) {
@@ -135,31 +209,51 @@ func createBucket(path Path, tx *bbolt.Tx) (*bbolt.Bucket, error) {
 	return bucket, nil
 }
 
-func CreateKey(name string, value []byte, path Path) error {
+func CreateKey(name, value []byte, path Path) error {
 	if len(path[0]) == 0 {
 		return errInvalidPath
 	}
-	return db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := createBucket(path, tx)
+	if err := validateValue(path, value); err != nil {
+		return err
+	}
+	key := childPath(path, name)
+	changes := []txChange{}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if err := checkLoaded(path, tx); err != nil {
+			return err
+		}
+		bucket, created, err := createMissing(path, tx)
 		if err != nil {
 			return err
 		}
-		if bucket.Get([]byte(name)) != nil {
+		if bucket.Get(name) != nil {
 			return errKeyExists
 		}
-		return bucket.Put([]byte(name), value)
+		if err := bucket.Put(name, value); err != nil {
+			return err
+		}
+		changes = append(createdBuckets(created, tx), txChange{op: "create key", from: key, to: key, new: snapshotAt(key, tx)})
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	writeChanges(changes, "")
+	return nil
 }
 
-func DeleteBucket(path Path) error {
-	if len(path[0]) == 0 {
+func DeleteBucket(node TreeNode) error {
+	if node.Path == nil {
 		return errInvalidPath
 	}
-	if path == nil {
+	if len(node.Path[0]) == 0 {
 		return errInvalidPath
 	}
-	return db.Update(func(tx *bbolt.Tx) error {
-		return deleteBucket(path, tx)
+	return recordChange("delete bucket", node.Path, nil, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
+			return err
+		}
+		return deleteBucket(node.Path, tx)
 	})
 }
 
@@ -179,12 +273,15 @@ func deleteBucket(path Path, tx *bbolt.Tx) error {
 	return parent.DeleteBucket(name)
 }
 
-func EmptyBucket(path Path) error {
-	if len(path[0]) == 0 {
+func EmptyBucket(node TreeNode) error {
+	if len(node.Path[0]) == 0 {
 		return errInvalidPath
 	}
-	return db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := getBucket(path, tx)
+	return recordChange("empty bucket", node.Path, node.Path, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
+			return err
+		}
+		bucket, err := getBucket(node.Path, tx)
 		if err != nil {
 			return err
 		}
@@ -200,65 +297,68 @@ func EmptyBucket(path Path) error {
 	})
 }
 
-func RenameItem(path Path, name string, isBucket bool) error {
-	if len(path[0]) == 0 {
+func RenameItem(node TreeNode, name []byte) error {
+	if len(node.Path[0]) == 0 {
 		return errInvalidPath
 	}
-	if isBucket {
-		return RenameBucket(path, name)
+	if node.IsBucket {
+		return RenameBucket(node, name)
 	}
-	return RenameKey(path, name)
+	return RenameKey(node, name)
 }
 
-func RenameBucket(path Path, newName string) error {
+func RenameBucket(node TreeNode, newName []byte) error {
+	path := node.Path
 	if len(path[0]) == 0 {
 		return errInvalidPath
 	}
-	currentName := path[len(path)-1]
-	newBucket := &bbolt.Bucket{}
-	oldBucket := &bbolt.Bucket{}
-	return db.Update(func(tx *bbolt.Tx) error {
-		parent, err := getParentBucket(path, tx)
-		if err != nil {
+	newPath := append(slices.Clone(path[:len(path)-1]), newName)
+	return recordChange("rename bucket", path, newPath, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
 			return err
 		}
-		if parent == nil {
-			newBucket, err = tx.CreateBucket([]byte(newName))
-			if err != nil {
-				return err
-			}
-			oldBucket = tx.Bucket(currentName)
-		} else {
-			newBucket, err = parent.CreateBucket([]byte(newName))
-			if err != nil {
-				return err
-			}
-			oldBucket = parent.Bucket(currentName)
+		return moveBucket(path, newPath, tx)
+	})
+}
+
+func transferContents(src, dst *bbolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	nested := [][]byte{}
+	if err := src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested = append(nested, k)
+			return nil
 		}
-		err = oldBucket.ForEach(func(k, v []byte) error {
-			return newBucket.Put(k, v)
-		})
-		if err != nil {
+		return dst.Put(k, v)
+	}); err != nil {
+		return err
+	}
+	// move nested buckets natively after iterating, so nested data is not rewritten
+	for _, name := range nested {
+		if err := src.MoveBucket(name, dst); err != nil {
 			return err
 		}
-		if parent == nil {
-			return tx.DeleteBucket(currentName)
-		}
-		return parent.DeleteBucket(currentName)
-	})
+	}
+	return nil
 }
 
-func RenameKey(path Path, newName string) error {
+func RenameKey(node TreeNode, newName []byte) error {
+	path := node.Path
 	if len(path[0]) == 0 {
 		return errInvalidPath
 	}
 	currentName := path[len(path)-1]
-	return db.Update(func(tx *bbolt.Tx) error {
+	return recordChange("rename key", path, childPath(parentPath(path), newName), func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
+			return err
+		}
 		bucket, err := getParentBucket(path, tx)
 		if err != nil {
 			return err
 		}
-		existing := bucket.Get([]byte(newName))
+		existing := bucket.Get(newName)
 		if existing != nil {
 			return errKeyExists
 		}
@@ -266,7 +366,7 @@ func RenameKey(path Path, newName string) error {
 		if key == nil {
 			return errInvalidPath
 		}
-		if err := bucket.Put([]byte(newName), key); err != nil {
+		if err := bucket.Put(newName, key); err != nil {
 			return err
 		}
 		return bucket.Delete(currentName)
@@ -275,24 +375,34 @@ func RenameKey(path Path, newName string) error {
 
 func DeleteItem(n *TreeNode) error {
 	if n.IsBucket {
-		return DeleteBucket(n.Path)
+		return DeleteBucket(*n)
 	}
-	return DeleteKey(n.Path)
+	return DeleteKey(*n)
 }
 
-func DeleteKey(path Path) error {
-	if len(path[0]) == 0 {
+func DeleteKey(node TreeNode) error {
+	if len(node.Path[0]) == 0 {
 		return errInvalidPath
 	}
-	return db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := getParentBucket(path, tx)
-		if err != nil {
+	return recordChange("delete key", node.Path, nil, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
 			return err
 		}
-		return bucket.Delete(path[len(path)-1])
+		return deleteKey(node.Path, tx)
 	})
 }
 
+func deleteKey(path Path, tx *bbolt.Tx) error {
+	if len(path) < 2 { //nolint:mnd //keys are always inside a bucket
+		return errInvalidPath
+	}
+	bucket, err := getParentBucket(path, tx)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(path[len(path)-1])
+}
+
 func getParentBucket(path Path, tx *bbolt.Tx) (*bbolt.Bucket, error) {
 	if len(path) == 1 {
 		// parent is root
@@ -311,55 +421,258 @@ func getBucket(path Path, tx *bbolt.Tx) (*bbolt.Bucket, error) {
 	}
 	for _, p := range path[1:] {
 		bucket = bucket.Bucket(p)
-	}
-	if bucket == nil {
-		return &bbolt.Bucket{}, errInvalidPath
+		if bucket == nil {
+			return &bbolt.Bucket{}, errInvalidPath
+		}
 	}
 	return bucket, nil
 }
 
-func CopyBucket(old, new Path) error {
-	if len(old[0]) == 0 || len(new[0]) == 0 {
+func CopyBucket(node TreeNode, new Path) error {
+	if len(node.Path[0]) == 0 || len(new[0]) == 0 {
+		return errInvalidPath
+	}
+	log.Println("copy bucket", node.Path, new)
+	return recordChange("copy bucket", node.Path, new, func(tx *bbolt.Tx) error {
+		return transferBucketTx(node, new, false, tx)
+	})
+}
+
+func MoveBucket(node TreeNode, new Path) error {
+	if len(node.Path[0]) == 0 || len(new[0]) == 0 {
 		return errInvalidPath
 	}
-	log.Println("copy bucket", old, new)
-	bucketName := old[len(old)-1]
-	return db.Update(func(tx *bbolt.Tx) error {
-		if len(old) == 1 {
-			bucket := tx.Bucket(bucketName)
-			return copyBucket(bucket, new, tx)
+	log.Println("move bucket", node.Path, new)
+	return recordChange("move bucket", node.Path, new, func(tx *bbolt.Tx) error {
+		return transferBucketTx(node, new, true, tx)
+	})
+}
+
+func transferBucket(src, dst *bbolt.DB, node TreeNode, new Path, move bool) error {
+	old := node.Path
+	if src.Path() != dst.Path() {
+		if err := src.View(func(tx *bbolt.Tx) error {
+			return checkNode(node, tx)
+		}); err != nil {
+			return err
 		}
-		bucket, err := getBucket(old, tx)
-		if err != nil {
+		if err := copyBucketChunked(src, dst, old, new); err != nil {
 			return err
 		}
-		return copyBucket(bucket, new, tx)
+		if !move {
+			return nil
+		}
+		return src.Update(func(tx *bbolt.Tx) error {
+			return deleteBucket(old, tx)
+		})
+	}
+	return dst.Update(func(tx *bbolt.Tx) error {
+		return transferBucketTx(node, new, move, tx)
 	})
 }
 
-func MoveBucket(old, new Path) error {
-	if len(old[0]) == 0 || len(new[0]) == 0 {
+func transferBucketTx(node TreeNode, new Path, move bool, tx *bbolt.Tx) error {
+	if isInside(new, node.Path) {
+		return errInsideSource
+	}
+	if err := checkNode(node, tx); err != nil {
+		return err
+	}
+	if move {
+		return moveBucket(node.Path, new, tx)
+	}
+	bucket, err := getBucket(node.Path, tx)
+	if err != nil {
+		return err
+	}
+	return copyBucket(bucket, new, tx)
+}
+
+// TransferItem copies or moves node into another open database.
+func TransferItem(node TreeNode, dst *bbolt.DB, new Path, move bool) error {
+	if len(new) == 0 || len(new[0]) == 0 {
 		return errInvalidPath
 	}
-	log.Println("move bucket", old, new)
-	bucketName := old[len(old)-1]
-	return db.Update(func(tx *bbolt.Tx) error {
-		if len(old) == 1 {
-			bucket := tx.Bucket(bucketName)
-			if err := copyBucket(bucket, new, tx); err != nil {
-				return err
-			}
-			return deleteBucket(old, tx)
+	log.Println("transfer", node.Path, "to", dst.Path(), new, move)
+	op := "copy to database"
+	after := node.Path
+	if move {
+		op = "move to database"
+		after = nil
+	}
+	var err error
+	if node.IsBucket {
+		err = transferBucket(db, dst, node, new, move)
+	} else {
+		err = transferKey(db, dst, node, new, move)
+	}
+	if err != nil {
+		return err
+	}
+	// the transfer checked that node is unchanged, so it is the old value;
+	// the other database is not audited here
+	old := newAuditValue(node)
+	change := txChange{op: op, from: node.Path, to: after, old: old}
+	if !move {
+		change.new = old
+	}
+	writeChanges([]txChange{change}, dst.Path()+": "+pathToString(new))
+	return nil
+}
+
+func transferKey(src, dst *bbolt.DB, node TreeNode, new Path, move bool) error {
+	if len(new) < 2 { //nolint:mnd //keys are always inside a bucket
+		return errKeyAtRoot
+	}
+	if err := src.View(func(tx *bbolt.Tx) error {
+		return checkNode(node, tx)
+	}); err != nil {
+		return err
+	}
+	if err := dst.Update(func(tx *bbolt.Tx) error {
+		parent, err := createBucket(parentPath(new), tx)
+		if err != nil {
+			return err
 		}
-		bucket, err := getBucket(old, tx)
+		name := new[len(new)-1]
+		if _, ok := getValue(parent, name); ok || parent.Bucket(name) != nil {
+			return errKeyExists
+		}
+		return parent.Put(name, node.Value)
+	}); err != nil {
+		return err
+	}
+	if !move {
+		return nil
+	}
+	return src.Update(func(tx *bbolt.Tx) error {
+		return deleteKey(node.Path, tx)
+	})
+}
+
+func moveBucket(old, new Path, tx *bbolt.Tx) error {
+	if isInside(new, old) {
+		return errInsideSource
+	}
+	name := old[len(old)-1]
+	newName := new[len(new)-1]
+	parent, err := getParentBucket(old, tx)
+	if err != nil {
+		return err
+	}
+	if _, err := getBucket(old, tx); err != nil {
+		return err
+	}
+	var newParent *bbolt.Bucket
+	if len(new) > 1 {
+		newParent, err = createBucket(new[:len(new)-1], tx)
 		if err != nil {
 			return err
 		}
-		if err := copyBucket(bucket, new, tx); err != nil {
+	}
+	if _, err := getBucket(new, tx); err == nil {
+		return errBucketExists
+	}
+	if bytes.Equal(name, newName) {
+		return tx.MoveBucket(name, parent, newParent)
+	}
+	// bbolt cannot rename a bucket, so move its contents into a new one
+	var oldBucket, newBucket *bbolt.Bucket
+	if parent == nil {
+		oldBucket = tx.Bucket(name)
+	} else {
+		oldBucket = parent.Bucket(name)
+	}
+	if newParent == nil {
+		newBucket, err = tx.CreateBucket(newName)
+	} else {
+		newBucket, err = newParent.CreateBucket(newName)
+	}
+	if err != nil {
+		return err
+	}
+	if err := transferContents(oldBucket, newBucket); err != nil {
+		return err
+	}
+	return deleteBucket(old, tx)
+}
+
+func isInside(path, parent Path) bool {
+	if len(path) <= len(parent) {
+		return false
+	}
+	for i, part := range parent {
+		if !bytes.Equal(part, path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type copyEntry struct {
+	path  Path
+	key   []byte
+	value []byte
+	seq   uint64
+}
+
+func copyBucketChunked(src, dst *bbolt.DB, old, new Path) error {
+	if err := dst.View(func(tx *bbolt.Tx) error {
+		if _, err := getBucket(new, tx); err == nil {
+			return errBucketExists
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	batch := []copyEntry{}
+	flush := func() error {
+		err := dst.Update(func(tx *bbolt.Tx) error {
+			for _, entry := range batch {
+				bucket, err := createBucket(entry.path, tx)
+				if err != nil {
+					return err
+				}
+				// entries without a key carry the bucket sequence
+				if entry.key == nil {
+					if err := bucket.SetSequence(entry.seq); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := bucket.Put(entry.key, entry.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+	var walk func(bucket *bbolt.Bucket, path Path) error
+	walk = func(bucket *bbolt.Bucket, path Path) error {
+		batch = append(batch, copyEntry{path: path, seq: bucket.Sequence()})
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return walk(bucket.Bucket(k), append(slices.Clone(path), bytes.Clone(k)))
+			}
+			batch = append(batch, copyEntry{path: path, key: bytes.Clone(k), value: bytes.Clone(v)})
+			if len(batch) >= copyChunkSize {
+				return flush()
+			}
+			return nil
+		})
+	}
+	if err := src.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(old, tx)
+		if err != nil {
 			return err
 		}
-		return deleteBucket(old, tx)
-	})
+		return walk(bucket, new)
+	}); err != nil {
+		return err
+	}
+	return flush()
 }
 
 func copyBucket(bucket *bbolt.Bucket, path Path, tx *bbolt.Tx) error {
@@ -384,76 +697,106 @@ func copyBucket(bucket *bbolt.Bucket, path Path, tx *bbolt.Tx) error {
 			return err
 		}
 	}
-	return bucket.ForEach(func(k, v []byte) error {
+	return copyContents(bucket, newBucket)
+}
+
+func copyContents(src, dst *bbolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
 		if v == nil {
-			path = append(path, k)
-			nested := bucket.Bucket(k)
-			return copyBucket(nested, path, tx)
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyContents(src.Bucket(k), nested)
 		}
-		return newBucket.Put(k, v)
+		return dst.Put(k, v)
 	})
 }
 
-func MoveKey(old, new Path) error {
-	if len(old[0]) == 0 || len(new[0]) == 0 {
+func MoveKey(node TreeNode, new Path) error {
+	if len(node.Path[0]) == 0 || len(new[0]) == 0 {
 		return errInvalidPath
 	}
-	keyName := old[len(old)-1]
-	newName := new[len(new)-1]
-	return db.Update(func(tx *bbolt.Tx) error {
-		parent, err := getParentBucket(old, tx)
-		if err != nil {
+	return recordChange("move key", node.Path, new, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
 			return err
 		}
-		oldValue := parent.Get(keyName)
-		if oldValue == nil {
-			return errInvalidPath
-		}
-		newParent, err := createBucket(new[:len(new)-1], tx)
-		if err != nil {
-			return err
-		}
-		// check if key exists
-		v := newParent.Get(newName)
-		if v != nil {
-			return errKeyExists
-		}
-		if err := newParent.Put(newName, v); err != nil {
+		return moveKey(node.Path, new, tx)
+	})
+}
+
+func moveKey(old, new Path, tx *bbolt.Tx) error {
+	if err := copyKey(old, new, tx); err != nil {
+		return err
+	}
+	return deleteKey(old, tx)
+}
+
+func CopyKey(node TreeNode, new Path) error {
+	if len(node.Path[0]) == 0 || len(new[0]) == 0 {
+		return errInvalidPath
+	}
+	return recordChange("copy key", node.Path, new, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
 			return err
 		}
-		return parent.Delete(keyName)
+		return copyKey(node.Path, new, tx)
 	})
 }
 
-func CopyKey(current, new Path) error {
-	if len(current[0]) == 0 || len(new[0]) == 0 {
+func copyKey(current, new Path, tx *bbolt.Tx) error {
+	if len(current) < 2 || len(new) < 2 { //nolint:mnd //keys are always inside a bucket
 		return errInvalidPath
 	}
 	currentName := current[len(current)-1]
 	newName := new[len(new)-1]
-	return db.Update(func(tx *bbolt.Tx) error {
-		parent, err := getParentBucket(current, tx)
-		if err != nil {
+	parent, err := getParentBucket(current, tx)
+	if err != nil {
+		return err
+	}
+	value := parent.Get(currentName)
+	if value == nil {
+		return errInvalidPath
+	}
+	newParent, err := createBucket(new[:len(new)-1], tx)
+	if err != nil {
+		return err
+	}
+	// check if key exists
+	if newParent.Get(newName) != nil {
+		return errKeyExists
+	}
+	return newParent.Put(newName, value)
+}
+
+func SetSequence(node TreeNode, seq uint64) error {
+	if len(node.Path[0]) == 0 {
+		return errInvalidPath
+	}
+	log.Println("set sequence", pathToString(node.Path), seq)
+	return recordChange("set sequence", node.Path, node.Path, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
 			return err
 		}
-		value := parent.Get(currentName)
-		if value == nil {
-			return errInvalidPath
-		}
-		newParent, err := createBucket(new[:len(new)-1], tx)
+		bucket, err := getBucket(node.Path, tx)
 		if err != nil {
 			return err
 		}
-		exists := parent.Get(newName)
-		if exists != nil {
-			return errKeyExists
-		}
-		return newParent.Put(newName, value)
+		return bucket.SetSequence(seq)
 	})
 }
 
 func UpdateKey(node TreeNode, value []byte) error {
-	return db.Update(func(tx *bbolt.Tx) error {
+	if err := validateValue(parentPath(node.Path), value); err != nil {
+		return err
+	}
+	return recordChange("update key", node.Path, node.Path, func(tx *bbolt.Tx) error {
+		if err := checkNode(node, tx); err != nil {
+			return err
+		}
 		parent, err := getParentBucket(node.Path, tx)
 		if err != nil {
 			return err
@@ -461,3 +804,13 @@ func UpdateKey(node TreeNode, value []byte) error {
 		return parent.Put(node.Name, value)
 	})
 }
+
+// getValue reports whether key holds a value (not a bucket), including empty
+// values that Get cannot tell apart from missing keys.
+func getValue(bucket *bbolt.Bucket, key []byte) ([]byte, bool) {
+	k, v := bucket.Cursor().Seek(key)
+	if !bytes.Equal(k, key) || bucket.Bucket(key) != nil {
+		return nil, false
+	}
+	return v, true
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
 	"cogentcore.org/core/core"
@@ -13,17 +17,23 @@ import (
 func createBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Create Bucket")
 	core.NewText(d).SetText("Parent Bucket")
-	parent := core.NewTextField(d).SetText(pathToString(node.Path))
+	parent := newBucketPicker(d, node.Path, true)
 	core.NewText(d).SetText("Bucket Name")
 	name := core.NewTextField(d)
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			path := Path{}
-			if parent.Text() != "" {
-				path = stringToPath(parent.Text())
+			path, err := parent.Path()
+			if err != nil {
+				core.ErrorDialog(button, err, "Create Bucket")
+				return
+			}
+			bucketName, err := parseName(path, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Create Bucket")
+				return
 			}
-			path = append(path, []byte(name.Text()))
+			path = append(path, bucketName)
 			if _, err := CreateBucket(path); err != nil {
 				core.ErrorDialog(button, err, "Create Bucket")
 				return
@@ -37,15 +47,11 @@ func createBucketDialog(node TreeNode, button *core.Button) {
 func deleteBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Delete Bucket")
 	core.NewText(d).SetText("Path")
-	core.NewTextField(d).SetText(pathToString(node.Path))
+	core.NewTextField(d).SetText(displayPath(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := DeleteBucket(node.Path); err != nil {
-				core.ErrorDialog(button, err, "Delete Bucket")
-				return
-			}
-			reload()
+			runChecked(button, "Delete Bucket", node, DeleteBucket)
 		})
 	})
 	d.RunDialog(button)
@@ -54,15 +60,11 @@ func deleteBucketDialog(node TreeNode, button *core.Button) {
 func emptyBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Empty Bucket")
 	core.NewText(d).SetText("Path")
-	core.NewTextField(d).SetText(pathToString(node.Path))
+	core.NewTextField(d).SetText(displayPath(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := EmptyBucket(node.Path); err != nil {
-				core.ErrorDialog(button, err, "Empty Bucket")
-				return
-			}
-			reload()
+			runChecked(button, "Empty Bucket", node, EmptyBucket)
 		})
 	})
 	d.RunDialog(button)
@@ -71,7 +73,7 @@ func emptyBucketDialog(node TreeNode, button *core.Button) {
 func addKeyDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Add Key")
 	core.NewText(d).SetText("Parent Bucket")
-	parent := core.NewTextField(d).SetText(pathToString(node.Path))
+	parent := newBucketPicker(d, node.Path, false)
 	core.NewText(d).SetText("Key Name")
 	name := core.NewTextField(d)
 	core.NewText(d).SetText("Key Value")
@@ -86,8 +88,17 @@ func addKeyDialog(node TreeNode, button *core.Button) {
 			}
 		})
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			path := stringToPath(parent.Text())
-			if err := CreateKey(name.Text(), toJSON(value.Text()), path); err != nil {
+			path, err := parent.Path()
+			if err != nil {
+				core.ErrorDialog(button, err, "Add Key")
+				return
+			}
+			keyName, err := parseName(path, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Add Key")
+				return
+			}
+			if err := CreateKey(keyName, toJSON(value.Text()), path); err != nil {
 				core.ErrorDialog(button, err, "Add Key")
 				return
 			}
@@ -100,18 +111,28 @@ func addKeyDialog(node TreeNode, button *core.Button) {
 func moveBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Move Bucket")
 	core.NewText(d).SetText("Current Path")
-	current := core.NewTextField(d).SetText(pathToString(node.Path))
-	core.NewText(d).SetText("New Path")
-	newPath := core.NewTextField(d)
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("New Parent Bucket")
+	parent := newBucketPicker(d, parentPath(node.Path), true)
+	core.NewText(d).SetText("New Name")
+	name := core.NewTextField(d).SetText(displayName(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := MoveBucket(stringToPath(current.Text()),
-				stringToPath(newPath.Text())); err != nil {
+			newPath, err := parent.Path()
+			if err != nil {
 				core.ErrorDialog(button, err, "Move Bucket")
 				return
 			}
-			reload()
+			newName, err := parseName(newPath, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Move Bucket")
+				return
+			}
+			newPath = append(newPath, newName)
+			runChecked(button, "Move Bucket", node, func(node TreeNode) error {
+				return MoveBucket(node, newPath)
+			})
 		})
 	})
 	d.RunDialog(button)
@@ -120,18 +141,28 @@ func moveBucketDialog(node TreeNode, button *core.Button) {
 func moveKeyDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Move Key")
 	core.NewText(d).SetText("Current Path")
-	current := core.NewTextField(d).SetText(pathToString(node.Path))
-	core.NewText(d).SetText("New Path")
-	newPath := core.NewTextField(d)
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("New Parent Bucket")
+	parent := newBucketPicker(d, parentPath(node.Path), false)
+	core.NewText(d).SetText("New Name")
+	name := core.NewTextField(d).SetText(displayName(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := MoveKey(stringToPath(current.Text()),
-				stringToPath(newPath.Text())); err != nil {
+			newPath, err := parent.Path()
+			if err != nil {
 				core.ErrorDialog(button, err, "Move Key")
 				return
 			}
-			reload()
+			newName, err := parseName(newPath, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Move Key")
+				return
+			}
+			newPath = append(newPath, newName)
+			runChecked(button, "Move Key", node, func(node TreeNode) error {
+				return MoveKey(node, newPath)
+			})
 		})
 	})
 	d.RunDialog(button)
@@ -140,15 +171,11 @@ func moveKeyDialog(node TreeNode, button *core.Button) {
 func deleteKeyDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Delete Key")
 	core.NewText(d).SetText("Path")
-	core.NewText(d).SetText(pathToString(node.Path))
+	core.NewText(d).SetText(displayPath(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := DeleteKey(node.Path); err != nil {
-				core.ErrorDialog(button, err, "Delete Key")
-				return
-			}
-			reload()
+			runChecked(button, "Delete Key", node, DeleteKey)
 		})
 	})
 	d.RunDialog(button)
@@ -157,7 +184,7 @@ func deleteKeyDialog(node TreeNode, button *core.Button) {
 func renameKeyDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Rename Key")
 	core.NewText(d).SetText("Path")
-	currentPath := core.NewTextField(d).SetText(pathToString(node.Path))
+	core.NewText(d).SetText(displayPath(node.Path))
 	core.NewText(d).SetText("New Name")
 	newName := core.NewTextField(d)
 	d.AddBottomBar(func(bar *core.Frame) {
@@ -167,11 +194,14 @@ func renameKeyDialog(node TreeNode, button *core.Button) {
 				core.ErrorDialog(button, errors.New("key name cannot contain spaces"), "Rename Key")
 				return
 			}
-			if err := RenameKey(stringToPath(currentPath.Text()), newName.Text()); err != nil {
+			name, err := parseName(parentPath(node.Path), newName.Text())
+			if err != nil {
 				core.ErrorDialog(button, err, "Rename Key")
 				return
 			}
-			reload()
+			runChecked(button, "Rename Key", node, func(node TreeNode) error {
+				return RenameKey(node, name)
+			})
 		})
 	})
 	d.RunDialog(button)
@@ -180,7 +210,7 @@ func renameKeyDialog(node TreeNode, button *core.Button) {
 func renameBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Rename Bucket")
 	core.NewText(d).SetText("Path")
-	currentPath := core.NewTextField(d).SetText(pathToString(node.Path))
+	core.NewText(d).SetText(displayPath(node.Path))
 	core.NewText(d).SetText("New Name")
 	newName := core.NewTextField(d)
 	d.AddBottomBar(func(bar *core.Frame) {
@@ -191,11 +221,14 @@ func renameBucketDialog(node TreeNode, button *core.Button) {
 					errors.New("bucket name cannot contain spaces"), "Rename Bucket")
 				return
 			}
-			if err := RenameBucket(stringToPath(currentPath.Text()), newName.Text()); err != nil {
+			name, err := parseName(parentPath(node.Path), newName.Text())
+			if err != nil {
 				core.ErrorDialog(button, err, "Rename Bucket")
 				return
 			}
-			reload()
+			runChecked(button, "Rename Bucket", node, func(node TreeNode) error {
+				return RenameBucket(node, name)
+			})
 		})
 	})
 	d.RunDialog(button)
@@ -204,18 +237,28 @@ func renameBucketDialog(node TreeNode, button *core.Button) {
 func copyKeyDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Copy Key")
 	core.NewText(d).SetText("Key Path")
-	currentPath := core.NewTextField(d).SetText(pathToString(node.Path))
-	core.NewText(d).SetText("New Path")
-	newPath := core.NewTextField(d)
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("New Parent Bucket")
+	parent := newBucketPicker(d, parentPath(node.Path), false)
+	core.NewText(d).SetText("New Name")
+	name := core.NewTextField(d).SetText(displayName(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			if err := CopyKey(stringToPath(currentPath.Text()),
-				stringToPath(newPath.Text())); err != nil {
+			newPath, err := parent.Path()
+			if err != nil {
 				core.ErrorDialog(button, err, "Copy Key")
 				return
 			}
-			reload()
+			newName, err := parseName(newPath, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Copy Key")
+				return
+			}
+			newPath = append(newPath, newName)
+			runChecked(button, "Copy Key", node, func(node TreeNode) error {
+				return CopyKey(node, newPath)
+			})
 		})
 	})
 	d.RunDialog(button)
@@ -224,20 +267,321 @@ func copyKeyDialog(node TreeNode, button *core.Button) {
 func copyBucketDialog(node TreeNode, button *core.Button) {
 	d := core.NewBody("Copy Bucket")
 	core.NewText(d).SetText("Bucket Path")
-	currentPath := core.NewTextField(d).SetText(pathToString(node.Path))
-	core.NewText(d).SetText("New Path")
-	newPath := core.NewTextField(d)
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("New Parent Bucket")
+	parent := newBucketPicker(d, parentPath(node.Path), true)
+	core.NewText(d).SetText("New Name")
+	name := core.NewTextField(d).SetText(displayName(node.Path))
 	d.AddBottomBar(func(bar *core.Frame) {
 		d.AddCancel(bar)
 		d.AddOK(bar).OnClick(func(e events.Event) {
-			node.Path = stringToPath(currentPath.Text())
-			if err := CopyBucket(stringToPath(currentPath.Text()),
-				stringToPath(newPath.Text())); err != nil {
+			newPath, err := parent.Path()
+			if err != nil {
 				core.ErrorDialog(button, err, "Copy Bucket")
 				return
 			}
+			newName, err := parseName(newPath, name.Text())
+			if err != nil {
+				core.ErrorDialog(button, err, "Copy Bucket")
+				return
+			}
+			newPath = append(newPath, newName)
+			runChecked(button, "Copy Bucket", node, func(node TreeNode) error {
+				return CopyBucket(node, newPath)
+			})
+		})
+	})
+	d.RunDialog(button)
+}
+
+func deleteRangeDialog(node TreeNode, button *core.Button) {
+	d := core.NewBody("Delete Range")
+	core.NewText(d).SetText("Bucket")
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("Key Prefix")
+	prefix := core.NewTextField(d)
+	core.NewText(d).SetText("or Start Key (inclusive)")
+	start := core.NewTextField(d)
+	core.NewText(d).SetText("and End Key (exclusive, empty for no limit)")
+	end := core.NewTextField(d)
+	parse := func(field *core.TextField) ([]byte, error) {
+		if field.Text() == "" {
+			return nil, nil
+		}
+		return parseName(node.Path, field.Text())
+	}
+	selected := func() (keyRange, error) {
+		var r keyRange
+		var err error
+		if r.Prefix, err = parse(prefix); err != nil {
+			return r, err
+		}
+		if r.Start, err = parse(start); err != nil {
+			return r, err
+		}
+		r.End, err = parse(end)
+		return r, err
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		core.NewButton(bar).SetText("Preview").OnClick(func(e events.Event) {
+			r, err := selected()
+			if err != nil {
+				core.ErrorDialog(bar, err, "Delete Range")
+				return
+			}
+			count, err := CountRange(node.Path, r)
+			if err != nil {
+				core.ErrorDialog(bar, err, "Delete Range")
+				return
+			}
+			core.MessageSnackbar(bar, strconv.Itoa(count)+" keys would be deleted")
+		})
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			r, err := selected()
+			if err != nil {
+				core.ErrorDialog(button, err, "Delete Range")
+				return
+			}
+			deleted, err := DeleteRange(node.Path, r, copyChunkSize)
+			// chunks deleted before an error stay deleted
+			reload()
+			if err != nil {
+				core.ErrorDialog(button, fmt.Errorf("%d keys deleted before the error: %w", deleted, err), "Delete Range")
+				return
+			}
+			core.MessageSnackbar(app, strconv.Itoa(deleted)+" keys deleted")
+		})
+	})
+	d.RunDialog(button)
+}
+
+func schemaDialog(node TreeNode, button *core.Button) {
+	d := core.NewBody("Bucket Schema")
+	core.NewText(d).SetText("JSON Schema for " + displayPath(node.Path) + " (empty to remove)")
+	schema, _ := getSchema(node.Path)
+	te := textcore.NewEditor(d)
+	buf := te.Lines.SetText(pretty(schema))
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			if err := SetSchema(node.Path, buf.Text()); err != nil {
+				core.ErrorDialog(button, err, "Bucket Schema")
+				return
+			}
 			reload()
 		})
 	})
 	d.RunDialog(button)
 }
+
+func validateBucketDialog(node TreeNode, button *core.Button) {
+	reports, err := ValidateBucket(node.Path)
+	if err != nil {
+		core.ErrorDialog(button, err, "Validate Bucket")
+		return
+	}
+	if len(reports) == 0 {
+		core.MessageSnackbar(button, "all keys match the schema")
+		return
+	}
+	d := core.NewBody("Validate Bucket")
+	core.NewText(d).SetText(strconv.Itoa(len(reports)) + " keys do not match the schema")
+	for _, report := range reports {
+		path := append(slices.Clone(node.Path), report.Key)
+		core.NewText(d).SetText(displayName(path) + ": " + report.Errors)
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddOK(bar)
+	})
+	d.RunDialog(button)
+}
+
+func updateKeyDialog(node TreeNode, value []byte, button *core.Button) {
+	if bytes.Equal(node.Value, value) {
+		core.MessageSnackbar(button, "no changes")
+		return
+	}
+	d := core.NewBody("Update Key")
+	core.NewText(d).SetText("Update " + displayPath(node.Path))
+	old, oldErr := parseJSON(node.Value)
+	edited, newErr := parseJSON(value)
+	if oldErr == nil && newErr == nil {
+		changes := []string{}
+		for _, line := range jsonDiff(old, edited) {
+			changes = append(changes, line.String())
+		}
+		core.NewText(d).SetText("Changes")
+		core.NewText(d).SetText(strings.Join(changes, "\n"))
+	}
+	stored, updated := []string{}, []string{}
+	for _, line := range lineDiff(pretty(node.Value), pretty(value)) {
+		switch line.Op {
+		case diffDelete:
+			stored = append(stored, line.String())
+			updated = append(updated, "")
+		case diffInsert:
+			stored = append(stored, "")
+			updated = append(updated, line.String())
+		default:
+			stored = append(stored, line.String())
+			updated = append(updated, line.String())
+		}
+	}
+	sides := core.NewSplits(d).SetSplits(.5, .5) //nolint:mnd //percentages
+	core.NewText(sides).SetText("Stored\n\n" + strings.Join(stored, "\n"))
+	core.NewText(sides).SetText("Edited\n\n" + strings.Join(updated, "\n"))
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).SetText("Update").OnClick(func(e events.Event) {
+			updateChecked(node, value, button)
+		})
+	})
+	d.RunDialog(button)
+}
+
+// runChecked runs op on node, offering to reload or overwrite if the database
+// no longer matches node.
+func runChecked(w core.Widget, title string, node TreeNode, op func(TreeNode) error) {
+	err := op(node)
+	if errors.Is(err, errConflict) {
+		conflictDialog(err, title, w, func() error {
+			current, err := currentNode(node.Path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", displayPath(node.Path), err)
+			}
+			return op(current)
+		}, nil)
+		return
+	}
+	if err != nil {
+		core.ErrorDialog(w, err, title)
+		return
+	}
+	reload()
+}
+
+// updateChecked is runChecked for value updates, which can also be merged
+// with the current value.
+func updateChecked(node TreeNode, value []byte, button *core.Button) {
+	update := func(node TreeNode) error {
+		return UpdateKey(node, value)
+	}
+	err := update(node)
+	if errors.Is(err, errConflict) {
+		conflictDialog(err, "Update Key", button, func() error {
+			current, err := currentNode(node.Path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", displayPath(node.Path), err)
+			}
+			return update(current)
+		}, func() {
+			current, err := currentNode(node.Path)
+			if err != nil {
+				core.ErrorDialog(button, fmt.Errorf("%s: %w", displayPath(node.Path), err), "Merge")
+				return
+			}
+			merged, err := mergeJSON(node.Value, value, current.Value)
+			if err != nil {
+				core.ErrorDialog(button, err, "Merge")
+				return
+			}
+			updateKeyDialog(current, merged, button)
+		})
+		return
+	}
+	if err != nil {
+		core.ErrorDialog(button, err, "Update Key")
+		return
+	}
+	reload()
+}
+
+func conflictDialog(err error, title string, w core.Widget, overwrite func() error, merge func()) {
+	d := core.NewBody(title + " Conflict")
+	core.NewText(d).SetText(err.Error())
+	core.NewText(d).SetText("Reload to see the current contents, or overwrite to apply the change to them anyway")
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		core.NewButton(bar).SetText("Reload").OnClick(func(e events.Event) {
+			d.Close()
+			reload()
+		})
+		if merge != nil {
+			core.NewButton(bar).SetText("Merge").OnClick(func(e events.Event) {
+				d.Close()
+				merge()
+			})
+		}
+		d.AddOK(bar).SetText("Overwrite").OnClick(func(e events.Event) {
+			if err := overwrite(); err != nil {
+				core.ErrorDialog(w, err, title)
+				return
+			}
+			reload()
+		})
+	})
+	d.RunDialog(w)
+}
+
+func copyToDatabaseDialog(node TreeNode, button *core.Button) {
+	others := otherSessions()
+	if len(others) == 0 {
+		core.MessageSnackbar(button, "no other database is open")
+		return
+	}
+	d := core.NewBody("Copy to Database")
+	core.NewText(d).SetText("Path")
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("Database")
+	labels := []string{}
+	for _, s := range others {
+		labels = append(labels, s.label)
+	}
+	target := core.NewChooser(d).SetStrings(labels...)
+	target.SetCurrentIndex(0)
+	selected := func() *session {
+		label, _ := target.CurrentItem.Value.(string)
+		idx := slices.IndexFunc(others, func(s *session) bool {
+			return s.label == label
+		})
+		if idx < 0 {
+			return nil
+		}
+		return others[idx]
+	}
+	core.NewText(d).SetText("Destination Bucket")
+	frame := core.NewFrame(d)
+	var dest *bucketPicker
+	showPicker := func() {
+		frame.DeleteChildren()
+		if s := selected(); s != nil {
+			dest = newBucketPickerFor(frame, s.db, s.file, s.formats, parentPath(node.Path), true)
+		}
+	}
+	showPicker()
+	target.OnChange(func(e events.Event) {
+		showPicker()
+		frame.Update()
+	})
+	move := core.NewSwitch(d).SetText("Move instead of copy")
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			s := selected()
+			if s == nil {
+				return
+			}
+			path, err := dest.Path()
+			if err != nil {
+				core.ErrorDialog(button, err, "Copy to Database")
+				return
+			}
+			newPath := childPath(path, node.Name)
+			runChecked(button, "Copy to Database", node, func(node TreeNode) error {
+				return TransferItem(node, s.db, newPath, move.IsChecked())
+			})
+		})
+	})
+	d.RunDialog(button)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// larger inputs are shown as a full replacement rather than paying for the
+// quadratic line diff
+const maxDiffLines = 2000
+
+type diffOp byte
+
+const (
+	diffEqual  diffOp = ' '
+	diffDelete diffOp = '-'
+	diffInsert diffOp = '+'
+	diffChange diffOp = '~'
+)
+
+type diffLine struct {
+	Op   diffOp
+	Text string
+}
+
+func (l diffLine) String() string {
+	return string(l.Op) + " " + l.Text
+}
+
+// lineDiff compares old and new line by line using the longest common
+// subsequence.
+func lineDiff(old, new []byte) []diffLine {
+	a := strings.Split(string(old), "\n")
+	b := strings.Split(string(new), "\n")
+	diff := []diffLine{}
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			diff = append(diff, diffLine{Op: diffDelete, Text: line})
+		}
+		for _, line := range b {
+			diff = append(diff, diffLine{Op: diffInsert, Text: line})
+		}
+		return diff
+	}
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, diffLine{Op: diffEqual, Text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, diffLine{Op: diffDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, diffLine{Op: diffInsert, Text: b[j]})
+			j++
+		}
+	}
+	return diff
+}
+
+// jsonDiff lists the fields that differ between two json documents, matching
+// object fields by name and array items by index.
+func jsonDiff(old, new *jsonValue) []diffLine {
+	return appendJSONDiff(nil, "", old, new)
+}
+
+func appendJSONDiff(diff []diffLine, path string, old, new *jsonValue) []diffLine {
+	if old.Kind != new.Kind || !old.isContainer() {
+		if !bytes.Equal(old.marshal(), new.marshal()) {
+			text := jsonPath(path) + ": " + string(old.marshal()) + " → " + string(new.marshal())
+			diff = append(diff, diffLine{Op: diffChange, Text: text})
+		}
+		return diff
+	}
+	if old.Kind == jsonArray {
+		for i := range max(len(old.Children), len(new.Children)) {
+			child := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(new.Children):
+				diff = append(diff, diffLine{Op: diffDelete, Text: child + ": " + string(old.Children[i].marshal())})
+			case i >= len(old.Children):
+				diff = append(diff, diffLine{Op: diffInsert, Text: child + ": " + string(new.Children[i].marshal())})
+			default:
+				diff = appendJSONDiff(diff, child, old.Children[i], new.Children[i])
+			}
+		}
+		return diff
+	}
+	for _, o := range old.Children {
+		child := path + "/" + o.Key
+		n := new.child(o.Key)
+		if n == nil {
+			diff = append(diff, diffLine{Op: diffDelete, Text: child + ": " + string(o.marshal())})
+			continue
+		}
+		diff = appendJSONDiff(diff, child, o, n)
+	}
+	for _, n := range new.Children {
+		if old.child(n.Key) == nil {
+			diff = append(diff, diffLine{Op: diffInsert, Text: path + "/" + n.Key + ": " + string(n.marshal())})
+		}
+	}
+	return diff
+}
+
+func jsonPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"cogentcore.org/core/base/fileinfo/mimedata"
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/events/key"
+	"cogentcore.org/core/styles"
+	"cogentcore.org/core/styles/abilities"
+)
+
+var (
+	errDropNotBucket  = errors.New("items can only be dropped on a bucket")
+	errDropSame       = errors.New("item is already in this bucket")
+	errDropKeyAtRoot  = errors.New("keys cannot be moved to the top level")
+	errDropInsideSelf = errors.New("bucket cannot be moved into itself")
+)
+
+func enableDragDrop(item *core.Tree, node TreeNode, root bool) {
+	// items stay read only so the default tree editing actions remain disabled
+	item.Parts.Styler(func(s *styles.Style) {
+		s.SetAbilities(!root, abilities.Draggable)
+		s.SetAbilities(node.IsBucket || root, abilities.Droppable)
+	})
+	item.Parts.On(events.DragStart, func(e events.Event) {
+		if root {
+			return
+		}
+		item.Scene.Events.DragStart(item, mimedata.NewText(item.Name), e)
+		e.SetHandled()
+	})
+	item.Parts.On(events.Drop, func(e events.Event) {
+		e.SetHandled()
+		de := e.(*events.DragDrop)
+		md, ok := de.Data.(mimedata.Mimes)
+		if !ok {
+			return
+		}
+		source, ok := nodeMap[md.Text(mimedata.TextPlain)]
+		if !ok {
+			return
+		}
+		target := node
+		if root {
+			target = TreeNode{IsBucket: true}
+		}
+		copyItem := de.HasAnyModifier(key.Control)
+		if err := validateDrop(source, target); err != nil {
+			core.ErrorSnackbar(item, err, "Drop")
+			return
+		}
+		dropDialog(source, target, copyItem, item)
+	})
+}
+
+func validateDrop(source, target TreeNode) error {
+	if !target.IsBucket {
+		return errDropNotBucket
+	}
+	if len(target.Path) == 0 && !source.IsBucket {
+		return errDropKeyAtRoot
+	}
+	if slices.EqualFunc(parentPath(source.Path), target.Path, bytes.Equal) {
+		return errDropSame
+	}
+	if source.IsBucket && (slices.EqualFunc(source.Path, target.Path, bytes.Equal) ||
+		isInside(target.Path, source.Path)) {
+		return errDropInsideSelf
+	}
+	return nil
+}
+
+func dropDialog(source, target TreeNode, copyItem bool, w core.Widget) {
+	action := "Move"
+	if copyItem {
+		action = "Copy"
+	}
+	kind := "Key"
+	if source.IsBucket {
+		kind = "Bucket"
+	}
+	title := action + " " + kind
+	newPath := append(slices.Clone(target.Path), source.Name)
+	destination := displayPath(target.Path)
+	if destination == "" {
+		destination = "top level"
+	}
+	d := core.NewBody(title)
+	core.NewText(d).SetText(action + " " + kind + " " + displayPath(source.Path))
+	core.NewText(d).SetText("To " + destination)
+	core.NewText(d).SetText("New Path " + displayPath(newPath))
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			op := MoveKey
+			switch {
+			case source.IsBucket && copyItem:
+				op = CopyBucket
+			case source.IsBucket:
+				op = MoveBucket
+			case copyItem:
+				op = CopyKey
+			}
+			runChecked(w, title, source, func(node TreeNode) error {
+				return op(node, newPath)
+			})
+		})
+	})
+	d.RunDialog(w)
+}
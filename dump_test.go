@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	openTestDB(t)
+	users := Path{[]byte("users")}
+	nested := Path{[]byte("users"), []byte("sub/x"), []byte("empty")}
+	binary := Path{[]byte("bin\x00\xff")}
+	for _, path := range []Path{nested, binary} {
+		if _, err := CreateBucket(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []struct {
+		bucket     Path
+		key, value []byte
+	}{
+		{users, []byte("alice"), []byte("{\"name\":\"é\"}\nsecond line")},
+		{binary, []byte{0x00, 0x01}, []byte{0xff, 0x00, 0x01}},
+		{parentPath(nested), []byte("blank"), []byte{}},
+	}
+	// longer than the old 64 MiB line limit once quoted
+	if !testing.Short() {
+		keys = append(keys, struct {
+			bucket     Path
+			key, value []byte
+		}{binary, []byte("large"), bytes.Repeat([]byte{0xff}, 17<<20)})
+	}
+	for _, k := range keys {
+		if err := CreateKey(k.key, k.value, k.bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, path := range []Path{parentPath(nested), binary} {
+		node, err := currentNode(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SetSequence(node, uint64(42+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var first bytes.Buffer
+	if err := Dump(&first); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(first.String(), "sequence 42") || !strings.Contains(first.String(), "sequence 43") {
+		t.Errorf("sequences missing from dump:\n%.2000s", first.String())
+	}
+
+	if err := openDB(filepath.Join(t.TempDir(), "restored.db")); err != nil {
+		t.Fatal(err)
+	}
+	items, err := Restore(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4 + len(keys); items != want {
+		t.Errorf("%d items restored, want %d", items, want)
+	}
+	var second bytes.Buffer
+	if err := Dump(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("dump of the restored database differs:\n%.2000s", second.String())
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	openTestDB(t)
+	for _, dump := range []string{
+		"",
+		"bucket \"a\"\n",
+		dumpHeader + "\nkey \"a\" \"v\"\n",
+		dumpHeader + "\nbucket \"a\" sequence x\n",
+		dumpHeader + "\nbucket \"a\" sequence 4xyz\n",
+		dumpHeader + "\nbucket \"a\" sequence -1\n",
+		dumpHeader + "\nbucket \"a\" sequence  4\n",
+		dumpHeader + "\nfoo \"a\"\n",
+	} {
+		if _, err := Restore(strings.NewReader(dump)); err == nil {
+			t.Errorf("%q restored", dump)
+		}
+	}
+}
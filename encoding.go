@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type keyFormat string
+
+const (
+	formatUTF8     keyFormat = "utf-8"
+	formatHex      keyFormat = "hex"
+	formatBase64   keyFormat = "base64"
+	formatUint64BE keyFormat = "uint64 BE"
+	formatUint64LE keyFormat = "uint64 LE"
+	formatInt64    keyFormat = "int64"
+	formatUUID     keyFormat = "UUID"
+	formatTime     keyFormat = "RFC3339 (unix nanos)"
+
+	uint64Size = 8
+	uuidSize   = 16
+)
+
+var (
+	keyFormats = []keyFormat{
+		formatUTF8, formatHex, formatBase64, formatUint64BE,
+		formatUint64LE, formatInt64, formatUUID, formatTime,
+	}
+	bucketFormats = make(map[string]keyFormat)
+	errKeyFormat  = errors.New("invalid key for format")
+)
+
+func formatFor(bucket Path) keyFormat {
+	return formatIn(bucketFormats, bucket)
+}
+
+// formatIn is formatFor with the formats of another session.
+func formatIn(formats map[string]keyFormat, bucket Path) keyFormat {
+	format, ok := formats[pathToString(bucket)]
+	if !ok {
+		return formatUTF8
+	}
+	return format
+}
+
+// displayName formats the last element of path with its parent's format.
+func displayName(path Path) string {
+	return displayNameIn(bucketFormats, path)
+}
+
+func displayNameIn(formats map[string]keyFormat, path Path) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return formatIn(formats, parentPath(path)).encode(path[len(path)-1])
+}
+
+func displayPath(path Path) string {
+	return displayPathIn(bucketFormats, path)
+}
+
+func displayPathIn(formats map[string]keyFormat, path Path) string {
+	parts := []string{}
+	for i := range path {
+		parts = append(parts, displayNameIn(formats, path[:i+1]))
+	}
+	return strings.Join(parts, "/")
+}
+
+func parseName(bucket Path, s string) ([]byte, error) {
+	return formatFor(bucket).decode(s)
+}
+
+// encode returns k in format f; keys that do not fit the format, or whose
+// text would start with 0x, are shown as hex with a 0x prefix so decode
+// always gives back the same key.
+func (f keyFormat) encode(k []byte) string {
+	switch f {
+	case formatHex:
+		return hex.EncodeToString(k)
+	case formatBase64:
+		if s := base64.StdEncoding.EncodeToString(k); !strings.HasPrefix(s, "0x") {
+			return s
+		}
+	case formatUint64BE, formatUint64LE, formatInt64, formatTime:
+		if len(k) != uint64Size {
+			break
+		}
+		switch f {
+		case formatUint64LE:
+			return strconv.FormatUint(binary.LittleEndian.Uint64(k), 10)
+		case formatInt64:
+			return strconv.FormatInt(int64(binary.BigEndian.Uint64(k)), 10) //nolint:gosec //intended
+		case formatTime:
+			nanos := int64(binary.BigEndian.Uint64(k)) //nolint:gosec //intended
+			return time.Unix(0, nanos).UTC().Format(time.RFC3339Nano)
+		default:
+			return strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+		}
+	case formatUUID:
+		if len(k) != uuidSize {
+			break
+		}
+		h := hex.EncodeToString(k)
+		return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	default:
+		if utf8.Valid(k) && !strings.HasPrefix(string(k), "0x") {
+			return string(k)
+		}
+	}
+	return "0x" + hex.EncodeToString(k)
+}
+
+func (f keyFormat) decode(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") {
+		return hex.DecodeString(s[2:])
+	}
+	k := make([]byte, uint64Size)
+	switch f {
+	case formatHex:
+		return hex.DecodeString(s)
+	case formatBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case formatUint64BE, formatUint64LE:
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if f == formatUint64LE {
+			binary.LittleEndian.PutUint64(k, v)
+		} else {
+			binary.BigEndian.PutUint64(k, v)
+		}
+		return k, nil
+	case formatInt64:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(k, uint64(v)) //nolint:gosec //intended
+		return k, nil
+	case formatTime:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(k, uint64(t.UnixNano())) //nolint:gosec //intended
+		return k, nil
+	case formatUUID:
+		k, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+		if err != nil {
+			return nil, err
+		}
+		if len(k) != uuidSize {
+			return nil, errKeyFormat
+		}
+		return k, nil
+	default:
+		return []byte(s), nil
+	}
+}
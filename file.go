@@ -1,19 +1,16 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"strings"
 
 	"cogentcore.org/core/core"
+	berrors "go.etcd.io/bbolt/errors"
 )
 
-func loadFile(filepath string) error {
-	closeDB()
-	if err := openDB(filepath); err != nil {
-		return err
-	}
-	reload()
-	return nil
+func isLocked(err error) bool {
+	return errors.Is(err, berrors.ErrTimeout)
 }
 
 func reload() {
@@ -21,16 +18,23 @@ func reload() {
 	path := strings.Split(dbFile, "/")
 	root := path[len(path)-1]
 	nodes := getNodes()
+	changedItems = make(map[string]bool)
 	panes.AsFrame().DeleteChildren()
 	left := core.NewFrame(panes)
 	core.NewFrame(panes)
-	tr := core.NewTree(left).SetText(root)
-	addNodes(tr, nodes)
-	tr.Scene.ContextMenus = nil
-	tr.ContextMenus = nil
-	tr.ContextMenus = append(tr.ContextMenus, mainContext)
-	tr.SetReadOnly(true)
+	newDBTree(left, root, nodes)
 	keyButton.SetEnabled(false)
 	bucketButton.SetEnabled(true)
 	app.Update()
 }
+
+func newDBTree(parent core.Widget, name string, nodes []*TreeNode) {
+	treeItems = make(map[string]*core.Tree)
+	dbTree = core.NewTree(parent).SetText(name)
+	addNodes(dbTree, nodes)
+	dbTree.Scene.ContextMenus = nil
+	dbTree.ContextMenus = nil
+	dbTree.ContextMenus = append(dbTree.ContextMenus, mainContext)
+	dbTree.SetReadOnly(true)
+	enableDragDrop(dbTree, TreeNode{}, true)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strconv"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+)
+
+// jsonRef locates a value in the document being edited; parent is nil for
+// the root.
+type jsonRef struct {
+	value  *jsonValue
+	parent *jsonValue
+	index  int
+}
+
+func (r jsonRef) label() string {
+	switch {
+	case r.parent == nil:
+		return r.value.summary()
+	case r.parent.Kind == jsonObject:
+		return r.value.Key + ": " + r.value.summary()
+	default:
+		return "[" + strconv.Itoa(r.index) + "]: " + r.value.summary()
+	}
+}
+
+type jsonEditor struct {
+	root   *jsonValue
+	list   *core.Frame
+	fields *core.Frame
+	refs   map[*core.Tree]jsonRef
+}
+
+func jsonEditorDialog(node TreeNode, value []byte, button *core.Button) {
+	title := "Edit " + displayPath(node.Path)
+	root, err := parseJSON(value)
+	if err != nil {
+		core.ErrorDialog(button, err, title)
+		return
+	}
+	d := core.NewBody(title)
+	panes := core.NewSplits(d).SetSplits(.5, .5) //nolint:mnd //percentages
+	editor := &jsonEditor{
+		root:   root,
+		list:   core.NewFrame(panes),
+		fields: core.NewFrame(panes),
+	}
+	editor.refresh(root)
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).SetText("Save").OnClick(func(e events.Event) {
+			value := root.marshal()
+			if err := validateValue(parentPath(node.Path), value); err != nil {
+				core.ErrorDialog(button, err, title)
+				return
+			}
+			updateKeyDialog(node, value, button)
+		})
+	})
+	d.RunWindowDialog(button)
+}
+
+// refresh rebuilds the tree after a change and shows the fields of target.
+func (e *jsonEditor) refresh(target *jsonValue) {
+	e.list.DeleteChildren()
+	e.refs = make(map[*core.Tree]jsonRef)
+	tr := core.NewTree(e.list)
+	e.addItem(tr, jsonRef{value: e.root})
+	tr.OnSelect(func(ev events.Event) {
+		selected := tr.GetSelectedNodes()
+		if len(selected) == 0 {
+			return
+		}
+		if ref, ok := e.refs[selected[0].AsCoreTree()]; ok {
+			e.show(ref)
+		}
+	})
+	shown := false
+	for item, ref := range e.refs {
+		if ref.value == target {
+			item.Select()
+			e.show(ref)
+			shown = true
+		}
+	}
+	if !shown {
+		e.show(jsonRef{value: e.root})
+	}
+	e.list.Update()
+}
+
+func (e *jsonEditor) addItem(item *core.Tree, ref jsonRef) {
+	item.SetText(ref.label())
+	item.SetReadOnly(true)
+	item.ContextMenus = nil
+	e.refs[item] = ref
+	for i, child := range ref.value.Children {
+		e.addItem(core.NewTree(item), jsonRef{value: child, parent: ref.value, index: i})
+	}
+}
+
+func (e *jsonEditor) show(ref jsonRef) {
+	e.fields.DeleteChildren()
+	value := ref.value
+	if ref.parent != nil && ref.parent.Kind == jsonObject {
+		core.NewText(e.fields).SetText("Field")
+		key := core.NewTextField(e.fields).SetText(value.Key)
+		key.OnChange(func(ev events.Event) {
+			if err := ref.parent.renameChild(ref.index, key.Text()); err != nil {
+				core.ErrorDialog(e.fields, err, "Rename Field")
+				return
+			}
+			e.refresh(value)
+		})
+	}
+	core.NewText(e.fields).SetText("Type")
+	kinds := []string{}
+	for _, kind := range jsonKinds {
+		kinds = append(kinds, string(kind))
+	}
+	kind := core.NewChooser(e.fields).SetStrings(kinds...)
+	kind.SetCurrentValue(string(value.Kind))
+	kind.OnChange(func(ev events.Event) {
+		selected, ok := kind.CurrentItem.Value.(string)
+		if !ok {
+			return
+		}
+		value.setKind(jsonKind(selected))
+		e.refresh(value)
+	})
+	switch value.Kind {
+	case jsonString, jsonNumber:
+		core.NewText(e.fields).SetText("Value")
+		text := core.NewTextField(e.fields).SetText(value.Scalar)
+		text.OnChange(func(ev events.Event) {
+			if err := value.setScalar(text.Text()); err != nil {
+				core.ErrorDialog(e.fields, err, "Set Value")
+				return
+			}
+			e.refresh(value)
+		})
+	case jsonBool:
+		check := core.NewSwitch(e.fields).SetText("Value").SetChecked(value.Scalar == "true")
+		check.OnChange(func(ev events.Event) {
+			value.setScalar(strconv.FormatBool(check.IsChecked())) //nolint:errcheck,gosec // always a bool
+			e.refresh(value)
+		})
+	case jsonObject, jsonArray:
+		text := "Add Item"
+		if value.Kind == jsonObject {
+			text = "Add Field"
+		}
+		core.NewButton(e.fields).SetText(text).OnClick(func(ev events.Event) {
+			child, err := value.addChild()
+			if err != nil {
+				core.ErrorDialog(e.fields, err, text)
+				return
+			}
+			e.refresh(child)
+		})
+	}
+	if ref.parent != nil {
+		buttons := core.NewFrame(e.fields)
+		core.NewButton(buttons).SetText("Move Up").OnClick(func(ev events.Event) {
+			e.move(ref, -1)
+		})
+		core.NewButton(buttons).SetText("Move Down").OnClick(func(ev events.Event) {
+			e.move(ref, 1)
+		})
+		core.NewButton(buttons).SetText("Remove").OnClick(func(ev events.Event) {
+			if err := ref.parent.removeChild(ref.index); err != nil {
+				core.ErrorDialog(e.fields, err, "Remove")
+				return
+			}
+			e.refresh(ref.parent)
+		})
+	}
+	e.fields.Update()
+}
+
+func (e *jsonEditor) move(ref jsonRef, delta int) {
+	if err := ref.parent.moveChild(ref.index, delta); err != nil {
+		core.MessageSnackbar(e.fields, "cannot move further")
+		return
+	}
+	e.refresh(ref.value)
+}
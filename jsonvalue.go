@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+type jsonKind string
+
+const (
+	jsonObject jsonKind = "object"
+	jsonArray  jsonKind = "array"
+	jsonString jsonKind = "string"
+	jsonNumber jsonKind = "number"
+	jsonBool   jsonKind = "bool"
+	jsonNull   jsonKind = "null"
+)
+
+var (
+	jsonKinds        = []jsonKind{jsonObject, jsonArray, jsonString, jsonNumber, jsonBool, jsonNull}
+	errTrailingData  = errors.New("unexpected data after json value")
+	errNotNumber     = errors.New("not a valid json number")
+	errNotBool       = errors.New("must be true or false")
+	errDuplicateKey  = errors.New("field already exists")
+	errNotContainer  = errors.New("value is not an object or array")
+	errIndexOutRange = errors.New("index out of range")
+)
+
+// jsonValue is a parsed json document that, unlike map[string]any, keeps
+// object fields in their original order.
+type jsonValue struct {
+	Kind     jsonKind
+	Key      string // field name when the parent is an object
+	Scalar   string // string contents, number literal or true/false
+	Children []*jsonValue
+}
+
+func parseJSON(data []byte) (*jsonValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errTrailingData
+	}
+	return value, nil
+}
+
+func decodeValue(dec *json.Decoder) (*jsonValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		value := &jsonValue{Kind: jsonArray}
+		if t == '{' {
+			value.Kind = jsonObject
+		}
+		for dec.More() {
+			key := ""
+			if value.Kind == jsonObject {
+				tok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ = tok.(string)
+			}
+			child, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			child.Key = key
+			value.Children = append(value.Children, child)
+		}
+		// closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case string:
+		return &jsonValue{Kind: jsonString, Scalar: t}, nil
+	case json.Number:
+		return &jsonValue{Kind: jsonNumber, Scalar: t.String()}, nil
+	case bool:
+		return &jsonValue{Kind: jsonBool, Scalar: strconv.FormatBool(t)}, nil
+	default:
+		return &jsonValue{Kind: jsonNull}, nil
+	}
+}
+
+func (v *jsonValue) marshal() []byte {
+	var buf bytes.Buffer
+	v.write(&buf)
+	return buf.Bytes()
+}
+
+func (v *jsonValue) write(buf *bytes.Buffer) {
+	switch v.Kind {
+	case jsonObject, jsonArray:
+		open, end := byte('['), byte(']')
+		if v.Kind == jsonObject {
+			open, end = '{', '}'
+		}
+		buf.WriteByte(open)
+		for i, child := range v.Children {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if v.Kind == jsonObject {
+				writeString(buf, child.Key)
+				buf.WriteByte(':')
+			}
+			child.write(buf)
+		}
+		buf.WriteByte(end)
+	case jsonString:
+		writeString(buf, v.Scalar)
+	case jsonNumber, jsonBool:
+		buf.WriteString(v.Scalar)
+	default:
+		buf.WriteString("null")
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s) //nolint:errcheck,gosec // strings always encode
+	// Encode adds a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+func (v *jsonValue) isContainer() bool {
+	return v.Kind == jsonObject || v.Kind == jsonArray
+}
+
+// summary is the label of v in the editor tree
+func (v *jsonValue) summary() string {
+	switch v.Kind {
+	case jsonObject:
+		return fmt.Sprintf("{%d}", len(v.Children))
+	case jsonArray:
+		return fmt.Sprintf("[%d]", len(v.Children))
+	case jsonString:
+		return strconv.Quote(v.Scalar)
+	case jsonNull:
+		return "null"
+	default:
+		return v.Scalar
+	}
+}
+
+// setKind changes the type of v, resetting its contents to the zero value of
+// the new type.
+func (v *jsonValue) setKind(kind jsonKind) {
+	if kind == v.Kind {
+		return
+	}
+	v.Kind = kind
+	v.Children = nil
+	switch kind {
+	case jsonNumber:
+		v.Scalar = "0"
+	case jsonBool:
+		v.Scalar = "false"
+	default:
+		v.Scalar = ""
+	}
+}
+
+func (v *jsonValue) setScalar(s string) error {
+	switch v.Kind {
+	case jsonNumber:
+		if !isNumber(s) {
+			return errNotNumber
+		}
+	case jsonBool:
+		if s != "true" && s != "false" {
+			return errNotBool
+		}
+	case jsonString:
+	default:
+		return nil
+	}
+	v.Scalar = s
+	return nil
+}
+
+func isNumber(s string) bool {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return false
+	}
+	if _, ok := tok.(json.Number); !ok {
+		return false
+	}
+	_, err = dec.Token()
+	return errors.Is(err, io.EOF)
+}
+
+func (v *jsonValue) child(key string) *jsonValue {
+	for _, child := range v.Children {
+		if child.Key == key {
+			return child
+		}
+	}
+	return nil
+}
+
+func (v *jsonValue) hasKey(key string) bool {
+	return v.child(key) != nil
+}
+
+func (v *jsonValue) renameChild(i int, key string) error {
+	if i < 0 || i >= len(v.Children) {
+		return errIndexOutRange
+	}
+	if v.Children[i].Key == key {
+		return nil
+	}
+	if v.hasKey(key) {
+		return fmt.Errorf("%s: %w", key, errDuplicateKey)
+	}
+	v.Children[i].Key = key
+	return nil
+}
+
+// addChild appends a null to an array or a new field to an object with an
+// unused name.
+func (v *jsonValue) addChild() (*jsonValue, error) {
+	if !v.isContainer() {
+		return nil, errNotContainer
+	}
+	child := &jsonValue{Kind: jsonNull}
+	if v.Kind == jsonObject {
+		child.Key = "field"
+		for i := 1; v.hasKey(child.Key); i++ {
+			child.Key = "field" + strconv.Itoa(i)
+		}
+	}
+	v.Children = append(v.Children, child)
+	return child, nil
+}
+
+func (v *jsonValue) removeChild(i int) error {
+	if i < 0 || i >= len(v.Children) {
+		return errIndexOutRange
+	}
+	v.Children = slices.Delete(v.Children, i, i+1)
+	return nil
+}
+
+func (v *jsonValue) moveChild(i, delta int) error {
+	j := i + delta
+	if i < 0 || i >= len(v.Children) || j < 0 || j >= len(v.Children) {
+		return errIndexOutRange
+	}
+	v.Children[i], v.Children[j] = v.Children[j], v.Children[i]
+	return nil
+}
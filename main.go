@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"cogentcore.org/core/core"
@@ -17,40 +18,42 @@ import (
 	"cogentcore.org/core/icons"
 	"cogentcore.org/core/text/textcore"
 	"cogentcore.org/core/tree"
-	berrors "go.etcd.io/bbolt/errors"
 )
 
 var (
-	app           *core.Body
-	panes         *core.Splits
-	selectedNode  TreeNode
-	bucketButton  *core.Button
-	keyButton     *core.Button
-	databaseInUse = "Database file is locked. Is the database in use by another application?"
+	app             *core.Body
+	panes           *core.Splits
+	dbTree          *core.Tree
+	treeItems       = make(map[string]*core.Tree)
+	selectedNode    TreeNode
+	bucketButton    *core.Button
+	keyButton       *core.Button
+	selectionButton *core.Button
+	auditButton     *core.Button
+	scriptButton    *core.Button
+	databaseInUse   = "Database file is locked. Is the database in use by another application?"
 )
 
 func main() { //nolint:funlen //todo
 	log.SetFlags(log.Lshortfile | log.Ltime)
+	if ok, err := runCommand(os.Args[1:]); ok {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	initSettings()
 	app = core.NewBody("BboltEditor")
 	dbfile := "test.db"
 	if len(os.Args) == 2 {
 		dbfile = os.Args[1]
 	}
-	if err := openDB(dbfile); err != nil {
-		if errors.Is(err, berrors.ErrTimeout) {
-			space := core.NewSpace(app)
-			core.MessageDialog(space, "Database in use by another application", "Database Error")
-		} else {
-			log.Fatal(err)
-		}
-	}
-	nodes := getNodes()
 	core.NewToolbar(app).Maker(func(p *tree.Plan) {
 		tree.Add(p, func(w *core.Button) {
 			w.SetText("File").OnClick(func(e events.Event) {
-				current, _ := os.Getwd()
+				dir := fileDialogDir()
 				d := core.NewBody("File")
-				ft := filetree.NewTree(d).OpenPath(current)
+				ft := filetree.NewTree(d).OpenPath(dir)
 				selected := ""
 				ft.OnSelect(func(e events.Event) {
 					ft.SelectedFunc(func(n *filetree.Node) {
@@ -60,15 +63,15 @@ func main() { //nolint:funlen //todo
 				d.AddBottomBar(func(bar *core.Frame) {
 					d.AddCancel(bar)
 					core.NewButton(bar).SetText("Open Parent").OnClick(func(e events.Event) {
-						current = filepath.Dir(current)
+						dir = filepath.Dir(dir)
 						ft.DeleteChildren()
-						ft.OpenPath(current)
+						ft.OpenPath(dir)
 						d.Update()
 					})
 					d.AddOK(bar).OnClick(func(e events.Event) {
 						log.Println("open file ", selected)
-						if err := loadFile(selected); err != nil {
-							if errors.Is(err, berrors.ErrTimeout) {
+						if err := newSession(selected); err != nil {
+							if isLocked(err) {
 								core.MessageDialog(d, databaseInUse, "Open Database")
 							} else {
 								core.ErrorDialog(d, err, "Open File")
@@ -79,6 +82,9 @@ func main() { //nolint:funlen //todo
 				d.RunDialog(w)
 			})
 		})
+		tree.Add(p, func(w *core.Button) {
+			w.SetText("Recent").SetMenu(recentContext)
+		})
 		tree.Add(p, func(w *core.Button) {
 			w.SetText("Bucket").SetMenu(bucketContext)
 			bucketButton = w
@@ -87,6 +93,25 @@ func main() { //nolint:funlen //todo
 			w.SetText("Key").SetMenu(keyContext).SetEnabled(false)
 			keyButton = w
 		})
+		tree.Add(p, func(w *core.Button) {
+			w.SetText("Selection").SetMenu(selectionContext)
+			selectionButton = w
+		})
+		tree.Add(p, func(w *core.Button) {
+			w.SetText("Bookmarks").SetMenu(bookmarksContext)
+		})
+		tree.Add(p, func(w *core.Button) {
+			w.SetText("Audit Log").OnClick(func(e events.Event) {
+				auditDialog("", w)
+			})
+			auditButton = w
+		})
+		tree.Add(p, func(w *core.Button) {
+			w.SetText("Script").OnClick(func(e events.Event) {
+				scriptDialog(w)
+			})
+			scriptButton = w
+		})
 		tree.Add(p, func(w *core.Button) {
 			w.SetText("Settings").OnClick(func(e events.Event) {
 				core.SettingsWindow()
@@ -99,23 +124,24 @@ func main() { //nolint:funlen //todo
 		})
 	})
 	core.NewSpace(app)
-	panes = core.NewSplits(app).SetSplits(.3, .7) //nolint:mnd //percentages
-	left := core.NewFrame(panes)
-	core.NewFrame(panes)
-
-	tr := core.NewTree(left).SetText(dbfile)
-	addNodes(tr, nodes)
-	tr.Scene.ContextMenus = nil
-	tr.ContextMenus = nil
-	tr.ContextMenus = append(tr.ContextMenus, mainContext)
-	tr.SetReadOnly(true)
+	tabs = core.NewTabs(app).SetType(core.FunctionalTabs).SetCloseTabFunc(closeSession)
+	if err := newSession(dbfile); err != nil {
+		if isLocked(err) {
+			core.MessageDialog(tabs, "Database in use by another application", "Database Error")
+		} else {
+			log.Fatal(err)
+		}
+	}
 
+	app.OnClose(func(e events.Event) {
+		saveAllState()
+	})
 	app.RunMainWindow()
 }
 
 func addNodes(t *core.Tree, nodes []*TreeNode) {
 	for _, node := range nodes {
-		item := core.NewTree(t).SetText(string(node.Name))
+		item := core.NewTree(t).SetText(displayName(node.Path))
 		item.SetReadOnly(true)
 		item.SetClosed(true)
 		item.ContextMenus = nil
@@ -131,9 +157,15 @@ func addNodes(t *core.Tree, nodes []*TreeNode) {
 			name = append(name, string(part))
 		}
 		item.Name = strings.Join(name, "/")
+		treeItems[item.Name] = item
+		if changedItems[item.Name] {
+			item.SetIcon(icons.NewReleases)
+			item.SetTooltip("changed on disk")
+		}
 		item.OnSelect(func(e events.Event) {
 			updateDetails(item.Name)
 		})
+		enableDragDrop(item, *node, false)
 		addNodes(item, node.Children)
 	}
 }
@@ -159,6 +191,19 @@ func keyContext(m *core.Scene, pos image.Point) {
 	core.NewButton(m).SetText("Copy Key").OnClick(func(e events.Event) {
 		copyKeyDialog(getNode(m), button)
 	})
+	core.NewButton(m).SetText("Apply Patch").OnClick(func(e events.Event) {
+		patchDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText("Copy to Database").OnClick(func(e events.Event) {
+		copyToDatabaseDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText(bookmarkText(getNode(m))).OnClick(func(e events.Event) {
+		toggleBookmark(getNode(m))
+	})
+	history := core.NewButton(m).SetText("History")
+	history.OnClick(func(e events.Event) {
+		auditDialog(displayPath(getNode(m).Path), history)
+	})
 }
 
 func bucketContext(m *core.Scene, pos image.Point) {
@@ -175,6 +220,18 @@ func bucketContext(m *core.Scene, pos image.Point) {
 	core.NewButton(m).SetText("Add Key").OnClick(func(e events.Event) {
 		addKeyDialog(getNode(m), button)
 	})
+	core.NewButton(m).SetText("Delete Range").OnClick(func(e events.Event) {
+		deleteRangeDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText("Transform Values").OnClick(func(e events.Event) {
+		transformDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText("Apply Patch").OnClick(func(e events.Event) {
+		patchDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText("Browse Keys").OnClick(func(e events.Event) {
+		browseDialog(getNode(m), button)
+	})
 	core.NewButton(m).SetText("Move Bucket").OnClick(func(e events.Event) {
 		moveBucketDialog(getNode(m), button)
 	})
@@ -184,6 +241,19 @@ func bucketContext(m *core.Scene, pos image.Point) {
 	core.NewButton(m).SetText("Copy Bucket").OnClick(func(e events.Event) {
 		copyBucketDialog(getNode(m), button)
 	})
+	core.NewButton(m).SetText("Copy to Database").OnClick(func(e events.Event) {
+		copyToDatabaseDialog(getNode(m), button)
+	})
+	core.NewButton(m).SetText("Select All Keys").OnClick(func(e events.Event) {
+		selectBucketKeys(getNode(m))
+	})
+	core.NewButton(m).SetText(bookmarkText(getNode(m))).OnClick(func(e events.Event) {
+		toggleBookmark(getNode(m))
+	})
+	history := core.NewButton(m).SetText("History")
+	history.OnClick(func(e events.Event) {
+		auditDialog(displayPath(getNode(m).Path), history)
+	})
 }
 
 func updateDetails(item string) {
@@ -204,9 +274,53 @@ func updateDetails(item string) {
 		keyButton.SetEnabled(true)
 	}
 	core.NewSpace(details)
-	core.NewText(details).SetText("Path:" + item)
-	core.NewText(details).SetText("Name:" + string(node.Name))
-	if !node.IsBucket {
+	core.NewText(details).SetText("Path:" + displayPath(node.Path))
+	core.NewText(details).SetText("Name:" + displayName(node.Path))
+	if node.IsBucket {
+		core.NewSpace(details)
+		formats := core.NewFrame(details)
+		core.NewText(formats).SetText("Key Format:")
+		names := []string{}
+		for _, format := range keyFormats {
+			names = append(names, string(format))
+		}
+		chooser := core.NewChooser(formats).SetStrings(names...)
+		chooser.SetCurrentValue(string(formatFor(node.Path)))
+		chooser.OnChange(func(e events.Event) {
+			format, ok := chooser.CurrentItem.Value.(string)
+			if !ok {
+				return
+			}
+			bucketFormats[item] = keyFormat(format)
+			reload()
+			saveAllState()
+		})
+		frame := core.NewFrame(details)
+		core.NewText(frame).SetText("Sequence:")
+		seq := core.NewTextField(frame).SetText(strconv.FormatUint(node.Sequence, 10))
+		setSeq := core.NewButton(frame).SetText("Set Sequence")
+		setSeq.OnClick(func(e events.Event) {
+			value, err := strconv.ParseUint(seq.Text(), 10, 64)
+			if err != nil {
+				core.ErrorDialog(details, err, "Set Sequence")
+				return
+			}
+			runChecked(setSeq, "Set Sequence", node, func(node TreeNode) error {
+				return SetSequence(node, value)
+			})
+		})
+		schemas := core.NewFrame(details)
+		schemaButton := core.NewButton(schemas).SetText("Schema")
+		schemaButton.OnClick(func(e events.Event) {
+			schemaDialog(node, schemaButton)
+		})
+		if _, ok := getSchema(node.Path); ok {
+			validate := core.NewButton(schemas).SetText("Validate Bucket")
+			validate.OnClick(func(e events.Event) {
+				validateBucketDialog(node, validate)
+			})
+		}
+	} else {
 		var reset *core.Button
 		core.NewSpace(details)
 		value := pretty(node.Value)
@@ -223,19 +337,37 @@ func updateDetails(item string) {
 		reset.OnClick(func(e events.Event) {
 			buf.SetText(node.Value)
 		})
+		problems := core.NewText(details)
 		core.NewButton(frame).SetText("Validate Json").OnClick(func(e events.Event) {
-			if json.Valid(buf.Text()) {
-				core.MessageSnackbar(details, "valid json")
-			} else {
+			if !json.Valid(buf.Text()) {
 				core.MessageSnackbar(details, "not valid json")
+				return
 			}
+			if err := validateValue(parentPath(node.Path), buf.Text()); err != nil {
+				problems.SetText(err.Error()).Update()
+				return
+			}
+			problems.SetText("").Update()
+			core.MessageSnackbar(details, "valid json")
+		})
+		treeButton := core.NewButton(frame).SetText("Edit as Tree")
+		treeButton.OnClick(func(e events.Event) {
+			jsonEditorDialog(node, buf.Text(), treeButton)
 		})
-		core.NewButton(frame).SetText("Update").OnClick(func(e events.Event) {
-			if err := UpdateKey(node, toJSON(buf.Text())); err != nil {
+		update := core.NewButton(frame).SetText("Update")
+		update.OnClick(func(e events.Event) {
+			value := toJSON(buf.Text())
+			err := validateValue(parentPath(node.Path), value)
+			var se *schemaError
+			if errors.As(err, &se) {
+				problems.SetText(se.Error()).Update()
+				return
+			}
+			if err != nil {
 				core.ErrorDialog(details, err, "Update Key")
 				return
 			}
-			reload()
+			updateKeyDialog(node, value, update)
 		})
 	}
 	app.Update()
@@ -249,14 +381,12 @@ func pretty(s []byte) []byte {
 	return data.Bytes()
 }
 
+// toJSON compacts json values as written, keeping the order of fields and
+// the form of numbers; other values are returned unchanged.
 func toJSON(orig []byte) []byte {
-	var temp any
-	if err := json.Unmarshal(orig, &temp); err != nil {
-		return orig
-	}
-	bytes, err := json.Marshal(temp)
-	if err != nil {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, orig); err != nil {
 		return orig
 	}
-	return bytes
+	return compact.Bytes()
 }
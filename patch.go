@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errPatchOp      = errors.New("invalid patch operation")
+	errPatchPath    = errors.New("path does not exist")
+	errPatchPointer = errors.New("invalid json pointer")
+	errPatchTest    = errors.New("test failed")
+	errPatchValue   = errors.New("value is required")
+	errPatchFailed  = errors.New("patch could not be applied")
+)
+
+type patchKind string
+
+const (
+	jsonPatch  patchKind = "JSON Patch"  // RFC 6902
+	mergePatch patchKind = "Merge Patch" // RFC 7386
+)
+
+var patchKinds = []patchKind{jsonPatch, mergePatch}
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+	value *jsonValue
+}
+
+// valuePatch is a parsed JSON Patch or Merge Patch document.
+type valuePatch struct {
+	kind  patchKind
+	ops   []patchOp
+	merge *jsonValue
+}
+
+// patchResult is the outcome of patching one key.
+type patchResult struct {
+	Key    string
+	Result string
+	failed bool
+}
+
+func parsePatch(kind patchKind, data []byte) (*valuePatch, error) {
+	patch := &valuePatch{kind: kind}
+	if kind == mergePatch {
+		var err error
+		patch.merge, err = parseJSON(data)
+		return patch, err
+	}
+	if err := json.Unmarshal(data, &patch.ops); err != nil {
+		return nil, err
+	}
+	for i := range patch.ops {
+		op := &patch.ops[i]
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d, %s: %w", i, op.Op, errPatchValue)
+			}
+			value, err := parseJSON(op.Value)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			op.value = value
+		case "remove", "move", "copy":
+		default:
+			return nil, fmt.Errorf("operation %d: %w %q", i, errPatchOp, op.Op)
+		}
+	}
+	return patch, nil
+}
+
+// apply returns the patched value; a patch that does not change the json
+// value returns value unchanged.
+func (p *valuePatch) apply(value []byte) ([]byte, error) {
+	original, err := parseJSON(value)
+	if err != nil {
+		return nil, errNotJSON
+	}
+	// the operations change doc in place
+	doc, _ := parseJSON(value)
+	if p.kind == mergePatch {
+		doc = mergeValue(doc, p.merge)
+	} else {
+		for i, op := range p.ops {
+			if doc, err = applyOp(doc, op); err != nil {
+				return nil, fmt.Errorf("operation %d, %s %s: %w", i, op.Op, op.Path, err)
+			}
+		}
+	}
+	if sameJSON(original, doc) {
+		return value, nil
+	}
+	return doc.marshal(), nil
+}
+
+// mergeValue applies a merge patch to target, keeping the order of existing
+// fields.
+func mergeValue(target, patch *jsonValue) *jsonValue {
+	if patch.Kind != jsonObject {
+		return patch.clone()
+	}
+	if target == nil || target.Kind != jsonObject {
+		target = &jsonValue{Kind: jsonObject}
+	}
+	for _, field := range patch.Children {
+		i, old := target.field(field.Key)
+		switch {
+		case field.Kind == jsonNull:
+			if old != nil {
+				target.removeChild(i) //nolint:errcheck,gosec //i is in range
+			}
+		case old != nil:
+			merged := mergeValue(old, field)
+			merged.Key = field.Key
+			target.Children[i] = merged
+		default:
+			merged := mergeValue(nil, field)
+			merged.Key = field.Key
+			target.Children = append(target.Children, merged)
+		}
+	}
+	return target
+}
+
+func (v *jsonValue) clone() *jsonValue {
+	c := &jsonValue{Kind: v.Kind, Key: v.Key, Scalar: v.Scalar}
+	for _, child := range v.Children {
+		c.Children = append(c.Children, child.clone())
+	}
+	return c
+}
+
+// parsePointer splits a json pointer (RFC 6901) into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q", errPatchPointer, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func resolvePointer(doc *jsonValue, tokens []string) (*jsonValue, error) {
+	value := doc
+	for _, token := range tokens {
+		if !value.isContainer() {
+			return nil, errPatchPath
+		}
+		if _, value = value.field(token); value == nil {
+			return nil, errPatchPath
+		}
+	}
+	return value, nil
+}
+
+// applyOp applies one operation and returns the new document, which is doc
+// itself unless the whole document is replaced.
+func applyOp(doc *jsonValue, op patchOp) (*jsonValue, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return addValue(doc, tokens, op.value.clone())
+	case "remove":
+		_, err := removeValue(doc, tokens)
+		return doc, err
+	case "replace":
+		return replaceValue(doc, tokens, op.value.clone())
+	case "test":
+		value, err := resolvePointer(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(value, op.value) {
+			return nil, errPatchTest
+		}
+		return doc, nil
+	}
+	from, err := parsePointer(op.From)
+	if err != nil {
+		return nil, err
+	}
+	value, err := resolvePointer(doc, from)
+	if err != nil {
+		return nil, fmt.Errorf("from %s: %w", op.From, err)
+	}
+	if op.Op == "copy" {
+		return addValue(doc, tokens, value.clone())
+	}
+	if len(tokens) > len(from) && slices.Equal(tokens[:len(from)], from) {
+		return nil, fmt.Errorf("%w: cannot move a value into itself", errPatchOp)
+	}
+	if value, err = removeValue(doc, from); err != nil {
+		return nil, err
+	}
+	return addValue(doc, tokens, value)
+}
+
+func addValue(doc *jsonValue, tokens []string, value *jsonValue) (*jsonValue, error) {
+	if len(tokens) == 0 {
+		value.Key = ""
+		return value, nil
+	}
+	parent, err := resolvePointer(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	name := tokens[len(tokens)-1]
+	switch parent.Kind {
+	case jsonObject:
+		value.Key = name
+		if i, old := parent.field(name); old != nil {
+			parent.Children[i] = value
+		} else {
+			parent.Children = append(parent.Children, value)
+		}
+	case jsonArray:
+		value.Key = ""
+		i := len(parent.Children)
+		if name != "-" {
+			var ok bool
+			if i, ok = arrayIndex(name); !ok || i > len(parent.Children) {
+				return nil, errIndexOutRange
+			}
+		}
+		parent.Children = slices.Insert(parent.Children, i, value)
+	default:
+		return nil, errPatchPath
+	}
+	return doc, nil
+}
+
+// replaceValue replaces an existing value, keeping its position.
+func replaceValue(doc *jsonValue, tokens []string, value *jsonValue) (*jsonValue, error) {
+	if len(tokens) == 0 {
+		value.Key = ""
+		return value, nil
+	}
+	parent, err := resolvePointer(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if !parent.isContainer() {
+		return nil, errPatchPath
+	}
+	i, old := parent.field(tokens[len(tokens)-1])
+	if old == nil {
+		return nil, errPatchPath
+	}
+	value.Key = old.Key
+	parent.Children[i] = value
+	return doc, nil
+}
+
+func removeValue(doc *jsonValue, tokens []string) (*jsonValue, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: the whole document cannot be removed", errPatchOp)
+	}
+	parent, err := resolvePointer(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if !parent.isContainer() {
+		return nil, errPatchPath
+	}
+	i, value := parent.field(tokens[len(tokens)-1])
+	if value == nil {
+		return nil, errPatchPath
+	}
+	return value, parent.removeChild(i)
+}
+
+// equalJSON compares values as RFC 6902 test does: regardless of field order
+// and number formatting.
+func equalJSON(a, b *jsonValue) bool {
+	var x, y any
+	if json.Unmarshal(a.marshal(), &x) != nil || json.Unmarshal(b.marshal(), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// patchNode applies the patch to a key through UpdateKey; with dryRun the
+// patched value is only checked against the bucket schema.
+func patchNode(node TreeNode, patch *valuePatch, dryRun bool) patchResult {
+	result := patchResult{Key: displayName(node.Path), Result: "unchanged"}
+	value, err := patch.apply(node.Value)
+	switch {
+	case err != nil:
+	case bytes.Equal(value, node.Value):
+		return result
+	case dryRun:
+		result.Result = "would be updated"
+		err = validateValue(parentPath(node.Path), value)
+	default:
+		result.Result = "updated"
+		err = UpdateKey(node, value)
+	}
+	if err != nil {
+		result.Result, result.failed = err.Error(), true
+	}
+	return result
+}
+
+// PatchBucket applies the patch to every key of a bucket, each key on its own
+// through UpdateKey, and reports the result for each key; keys that fail are
+// left unchanged. With dryRun nothing is written.
+func PatchBucket(path Path, patch *valuePatch, dryRun bool) ([]patchResult, error) {
+	nodes := []TreeNode{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				nodes = append(nodes, TreeNode{Path: childPath(path, k), Name: bytes.Clone(k), Value: bytes.Clone(v)})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := []patchResult{}
+	for _, node := range nodes {
+		results = append(results, patchNode(node, patch, dryRun))
+	}
+	return results, nil
+}
+
+func patchCommand(args []string) error {
+	flags := flag.NewFlagSet("patch", flag.ContinueOnError)
+	merge := flags.Bool("merge", false, "the patch is a merge patch (RFC 7386) instead of a JSON Patch (RFC 6902)")
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 3 { //nolint:mnd //dbfile, path and patch
+		return errUsage
+	}
+	var data []byte
+	var err error
+	if flags.Arg(2) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(flags.Arg(2))
+	}
+	if err != nil {
+		return err
+	}
+	kind := jsonPatch
+	if *merge {
+		kind = mergePatch
+	}
+	patch, err := parsePatch(kind, data)
+	if err != nil {
+		return err
+	}
+	if err := openDB(flags.Arg(0)); err != nil {
+		return err
+	}
+	defer closeDB()
+	node, err := currentNode(stringToPath(flags.Arg(1)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(1), err)
+	}
+	var results []patchResult
+	if node.IsBucket {
+		if results, err = PatchBucket(node.Path, patch, *dryRun); err != nil {
+			return err
+		}
+	} else {
+		results = []patchResult{patchNode(node, patch, *dryRun)}
+	}
+	failed := 0
+	for _, result := range results {
+		fmt.Printf("%s: %s\n", result.Key, result.Result)
+		if result.failed {
+			failed++
+		}
+	}
+	fmt.Println(len(results), "keys,", failed, "failed")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d keys", errPatchFailed, failed)
+	}
+	return nil
+}
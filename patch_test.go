@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+type patchCase struct {
+	name  string
+	doc   string
+	patch string
+	want  string // empty if the patch must fail
+}
+
+func testPatches(t *testing.T, kind patchKind, cases []patchCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parsePatch(kind, []byte(c.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.apply([]byte(c.doc))
+			switch {
+			case c.want == "" && err == nil:
+				t.Errorf("got %s, want an error", got)
+			case c.want != "" && err != nil:
+				t.Errorf("error %v, want %s", err, c.want)
+			case string(got) != c.want:
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+// the examples of RFC 6902 appendix A
+func TestJSONPatch(t *testing.T) {
+	testPatches(t, jsonPatch, []patchCase{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`},
+		{"add element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{
+			"move member",
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{
+			"test",
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ""},
+		{"test ignores field order", `{"a":{"x":1,"y":2}}`, `[{"op":"test","path":"/a","value":{"y":2,"x":1.0}}]`, `{"a":{"x":1,"y":2}}`},
+		{"add nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ""},
+		{"escapes", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`},
+		{"string is not number", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ""},
+		{"add array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"replace document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{"copy", `{"a":1}`, `[{"op":"copy","from":"/a","path":"/b"}]`, `{"a":1,"b":1}`},
+		{"move into child", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, ""},
+		{"remove end", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-"}]`, ""},
+	})
+}
+
+// RFC 6901 array indices are digits only, without a sign or leading zeros
+func TestPatchIndex(t *testing.T) {
+	testPatches(t, jsonPatch, []patchCase{
+		{"zero", `[1,2]`, `[{"op":"remove","path":"/0"}]`, `[2]`},
+		{"ten", `[0,1,2,3,4,5,6,7,8,9,10]`, `[{"op":"remove","path":"/10"}]`, `[0,1,2,3,4,5,6,7,8,9]`},
+		{"end", `[1,2]`, `[{"op":"add","path":"/2","value":3}]`, `[1,2,3]`},
+		{"past end", `[1,2]`, `[{"op":"add","path":"/3","value":3}]`, ""},
+		{"sign", `[1,2]`, `[{"op":"remove","path":"/+1"}]`, ""},
+		{"negative", `[1,2]`, `[{"op":"remove","path":"/-1"}]`, ""},
+		{"leading zero", `[1,2]`, `[{"op":"remove","path":"/01"}]`, ""},
+		{"add with sign", `[1,2]`, `[{"op":"add","path":"/+0","value":0}]`, ""},
+		{"add with leading zero", `[1,2]`, `[{"op":"add","path":"/00","value":0}]`, ""},
+		{"test with leading zero", `[1,2]`, `[{"op":"test","path":"/01","value":2}]`, ""},
+	})
+}
+
+// the examples of RFC 7386 appendix A
+func TestMergePatch(t *testing.T) {
+	testPatches(t, mergePatch, []patchCase{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"remove one member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"array by string", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"string by array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"arrays are replaced", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"array document", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"object by array", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"null document", `{"a":"foo"}`, `null`, `null`},
+		{"string document", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"null values kept", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"array by object", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"nested nulls", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{"invalid document", `not json`, `{"a":"foo"}`, ""},
+	})
+}
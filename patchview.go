@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/text/textcore"
+)
+
+const patchExample = `[
+  {"op": "replace", "path": "/status", "value": "active"}
+]`
+
+// patchDialog applies a JSON Patch or Merge Patch to a key, after showing the
+// change, or to every key of a bucket, listing the keys that failed.
+func patchDialog(node TreeNode, button *core.Button) {
+	title := "Apply Patch"
+	s := current
+	d := core.NewBody(title)
+	core.NewText(d).SetText(itemKind(node.IsBucket) + " " + displayPath(node.Path))
+	names := []string{}
+	for _, kind := range patchKinds {
+		names = append(names, string(kind))
+	}
+	chooser := core.NewChooser(d).SetStrings(names...)
+	chooser.SetCurrentValue(string(jsonPatch))
+	te := textcore.NewEditor(d)
+	buf := te.Lines.SetText([]byte(patchExample))
+	parse := func() (*valuePatch, error) {
+		kind, _ := chooser.CurrentItem.Value.(string)
+		return parsePatch(patchKind(kind), buf.Text())
+	}
+	if !node.IsBucket {
+		d.AddBottomBar(func(bar *core.Frame) {
+			d.AddCancel(bar)
+			d.AddOK(bar).SetText("Apply").OnClick(func(e events.Event) {
+				patch, err := parse()
+				if err != nil {
+					core.ErrorDialog(button, err, title)
+					return
+				}
+				value, err := patch.apply(node.Value)
+				if err != nil {
+					core.ErrorDialog(button, err, title)
+					return
+				}
+				if bytes.Equal(value, node.Value) {
+					core.MessageSnackbar(button, "no changes")
+					return
+				}
+				updateKeyDialog(node, value, button)
+			})
+		})
+		d.RunDialog(button)
+		return
+	}
+	results := []patchResult{}
+	status := core.NewText(d)
+	table := core.NewTable(d)
+	table.SetReadOnly(true)
+	table.SetSlice(&results)
+	run := func(dryRun bool) {
+		patch, err := parse()
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		var all []patchResult
+		err = withSession(s, func() error {
+			var err error
+			if all, err = PatchBucket(node.Path, patch, dryRun); err != nil {
+				return err
+			}
+			if !dryRun && slices.ContainsFunc(all, func(result patchResult) bool { return result.Result != "unchanged" && !result.failed }) {
+				reload()
+			}
+			return nil
+		})
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		// only changed and failed keys are listed
+		results = results[:0]
+		failed := 0
+		for _, result := range all {
+			if result.failed {
+				failed++
+			}
+			if result.Result != "unchanged" {
+				results = append(results, result)
+			}
+		}
+		changed := len(results) - failed
+		text := strconv.Itoa(changed) + " keys updated"
+		if dryRun {
+			text = strconv.Itoa(changed) + " keys would be updated"
+		}
+		text += ", " + strconv.Itoa(len(all)-len(results)) + " unchanged, " + strconv.Itoa(failed) + " failed"
+		status.SetText(text).Update()
+		table.Update()
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar).SetText("Close")
+		core.NewButton(bar).SetText("Dry Run").OnClick(func(e events.Event) {
+			run(true)
+		})
+		core.NewButton(bar).SetText("Apply").OnClick(func(e events.Event) {
+			run(false)
+		})
+	})
+	d.RunWindowDialog(button)
+}
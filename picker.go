@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/icons"
+	"cogentcore.org/core/styles"
+	"cogentcore.org/core/text/parse/complete"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errBucketNotFound = errors.New("bucket does not exist, use New Bucket to create it")
+	errRootNotAllowed = errors.New("a bucket must be selected")
+)
+
+// bucketPicker shows bucket paths in their display format; paths typed in
+// the field are matched against the existing buckets, so names containing
+// "/" still resolve to the right bucket.
+type bucketPicker struct {
+	field     *core.TextField
+	allowRoot bool
+	formats   map[string]keyFormat
+	buckets   map[string]Path
+	created   map[string]Path
+}
+
+func newBucketPicker(parent core.Widget, path Path, allowRoot bool) *bucketPicker {
+	return newBucketPickerFor(parent, db, dbFile, bucketFormats, path, allowRoot)
+}
+
+// newBucketPickerFor picks a bucket of another open database; path is
+// only preselected if it exists there.
+func newBucketPickerFor(parent core.Widget, database *bbolt.DB, file string, formats map[string]keyFormat,
+	path Path, allowRoot bool,
+) *bucketPicker {
+	picker := &bucketPicker{
+		allowRoot: allowRoot,
+		formats:   formats,
+		buckets:   make(map[string]Path),
+		created:   make(map[string]Path),
+	}
+	frame := core.NewFrame(parent)
+	frame.Styler(func(s *styles.Style) {
+		s.Direction = styles.Column
+	})
+	row := core.NewFrame(frame)
+	picker.field = core.NewTextField(row)
+	picker.field.SetCompleter(picker, picker.match, picker.edit)
+	core.NewButton(row).SetText("New Bucket").SetIcon(icons.Add).OnClick(func(e events.Event) {
+		picker.newBucketDialog(row)
+	})
+	list := core.NewFrame(frame)
+	list.Styler(func(s *styles.Style) {
+		s.Max.Y.Em(15) //nolint:mnd //reasonable height
+		s.Overflow.Y = styles.OverflowAuto
+	})
+	root := strings.Split(file, "/")
+	tr := core.NewTree(list).SetText(root[len(root)-1])
+	tr.SetReadOnly(true)
+	tr.OnSelect(func(e events.Event) {
+		if tr.IsRootSelected() {
+			picker.field.SetText("")
+		}
+	})
+	picker.addBuckets(tr, bucketPaths(database))
+	if text := displayPathIn(formats, path); picker.buckets[text] != nil {
+		picker.field.SetText(text)
+	}
+	return picker
+}
+
+// addBuckets adds the tree items for paths, which list parents before their
+// children.
+func (picker *bucketPicker) addBuckets(root *core.Tree, paths []Path) {
+	parents := []*core.Tree{root}
+	for _, path := range paths {
+		parents = parents[:len(path)]
+		item := core.NewTree(parents[len(path)-1]).SetText(displayNameIn(picker.formats, path))
+		item.SetReadOnly(true)
+		item.SetClosed(true)
+		item.SetIcon(icons.Colors)
+		text := displayPathIn(picker.formats, path)
+		picker.buckets[text] = path
+		item.OnSelect(func(e events.Event) {
+			picker.field.SetText(text)
+		})
+		parents = append(parents, item)
+	}
+}
+
+func (picker *bucketPicker) newBucketDialog(w core.Widget) {
+	d := core.NewBody("New Bucket")
+	core.NewText(d).SetText("Parent Bucket")
+	core.NewText(d).SetText(picker.field.Text())
+	core.NewText(d).SetText("Bucket Name")
+	name := core.NewTextField(d)
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			if name.Text() == "" {
+				core.ErrorDialog(w, errInvalidPath, "New Bucket")
+				return
+			}
+			parent, err := picker.lookup(picker.field.Text())
+			if err != nil {
+				core.ErrorDialog(w, err, "New Bucket")
+				return
+			}
+			key, err := formatIn(picker.formats, parent).decode(name.Text())
+			if err != nil {
+				core.ErrorDialog(w, err, "New Bucket")
+				return
+			}
+			newPath := childPath(parent, key)
+			text := displayPathIn(picker.formats, newPath)
+			picker.created[text] = newPath
+			picker.field.SetText(text)
+		})
+	})
+	d.RunDialog(w)
+}
+
+func (picker *bucketPicker) bucketPaths() []string {
+	paths := slices.Collect(maps.Keys(picker.buckets))
+	for text := range picker.created {
+		paths = append(paths, text)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+func (picker *bucketPicker) match(data any, text string, posLine, posChar int) complete.Matches {
+	matches := complete.Matches{Seed: text}
+	for _, path := range picker.bucketPaths() {
+		if strings.HasPrefix(path, text) {
+			matches.Matches = append(matches.Matches, complete.Completion{Text: path, Icon: icons.Colors})
+		}
+	}
+	return matches
+}
+
+func (picker *bucketPicker) edit(data any, text string, cursorPos int, comp complete.Completion,
+	seed string,
+) complete.Edit {
+	return complete.Edit{
+		NewText:       comp.Text,
+		ForwardDelete: len([]rune(text)),
+	}
+}
+
+// Path returns the selected bucket; buckets that do not exist are only
+// accepted when they were added with the New Bucket button. The path is a
+// copy, so callers may append to it.
+func (picker *bucketPicker) Path() (Path, error) {
+	path, err := picker.lookup(picker.field.Text())
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 && !picker.allowRoot {
+		return nil, errRootNotAllowed
+	}
+	return slices.Clone(path), nil
+}
+
+func (picker *bucketPicker) lookup(text string) (Path, error) {
+	if text == "" {
+		return Path{}, nil
+	}
+	if path, ok := picker.buckets[text]; ok {
+		return path, nil
+	}
+	if path, ok := picker.created[text]; ok {
+		return path, nil
+	}
+	return nil, errBucketNotFound
+}
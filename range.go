@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errEmptyRange    = errors.New("a prefix or start key is required")
+	errPrefixOrRange = errors.New("use either a prefix or a start/end range, not both")
+)
+
+// keyRange selects keys with Prefix, or keys in [Start, End); an empty End is
+// unbounded.
+type keyRange struct {
+	Prefix []byte
+	Start  []byte
+	End    []byte
+}
+
+func (r keyRange) validate() error {
+	if len(r.Prefix) > 0 && (len(r.Start) > 0 || len(r.End) > 0) {
+		return errPrefixOrRange
+	}
+	if len(r.Prefix) == 0 && len(r.Start) == 0 {
+		return errEmptyRange
+	}
+	return nil
+}
+
+func (r keyRange) first() []byte {
+	if len(r.Prefix) > 0 {
+		return r.Prefix
+	}
+	return r.Start
+}
+
+func (r keyRange) contains(k []byte) bool {
+	if len(r.Prefix) > 0 {
+		return bytes.HasPrefix(k, r.Prefix)
+	}
+	return bytes.Compare(k, r.Start) >= 0 && (len(r.End) == 0 || bytes.Compare(k, r.End) < 0)
+}
+
+// rangeKeys returns up to limit keys of the bucket within r, skipping nested
+// buckets; a limit of 0 returns all keys.
+func rangeKeys(bucket *bbolt.Bucket, r keyRange, limit int) [][]byte {
+	keys := [][]byte{}
+	c := bucket.Cursor()
+	for k, v := c.Seek(r.first()); k != nil && r.contains(k); k, v = c.Next() {
+		if v == nil {
+			continue
+		}
+		keys = append(keys, bytes.Clone(k))
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+	}
+	return keys
+}
+
+func CountRange(path Path, r keyRange) (int, error) {
+	if err := r.validate(); err != nil {
+		return 0, err
+	}
+	count := 0
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(r.first()); k != nil && r.contains(k); k, v = c.Next() {
+			if v != nil {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// DeleteRange deletes the keys of a bucket within r, committing every chunk
+// keys so very large ranges do not build a single huge transaction.
+func DeleteRange(path Path, r keyRange, chunk int) (int, error) {
+	if err := r.validate(); err != nil {
+		return 0, err
+	}
+	if chunk < 1 {
+		chunk = copyChunkSize
+	}
+	log.Printf("delete range %s prefix=%q start=%q end=%q", pathToString(path), r.Prefix, r.Start, r.End)
+	total := 0
+	// each chunk is recorded, as each is committed on its own
+	detail := fmt.Sprintf("prefix=%q start=%q end=%q", r.Prefix, r.Start, r.End)
+	for {
+		deleted := 0
+		err := recordChanges("delete range", detail, []Path{path}, []Path{path}, func(tx *bbolt.Tx) error {
+			if err := checkLoaded(path, tx); err != nil {
+				return err
+			}
+			bucket, err := getBucket(path, tx)
+			if err != nil {
+				return err
+			}
+			for _, k := range rangeKeys(bucket, r, chunk) {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < chunk {
+			return total, nil
+		}
+	}
+}
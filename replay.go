@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errReplayOp    = errors.New("operation cannot be replayed")
+	errReplayValue = errors.New("value was not recorded")
+	errReplayState = errors.New("does not match the script")
+	errDryRun      = errors.New("dry run")
+)
+
+// backups made before bulk changes hold whole values, not just the first
+// auditValueLimit bytes
+const maxScriptLine = 64 << 20
+
+// scriptStep is one line of a change script. Scripts use the audit log
+// format, so a recorded log, or part of it, can be replayed as is.
+type scriptStep struct {
+	auditEntry
+	Line int
+}
+
+type replayResult struct {
+	Line   int
+	Op     string
+	Path   string
+	Status string
+	old    *auditValue
+	new    *auditValue
+}
+
+func readScript(r io.Reader) ([]scriptStep, error) {
+	steps := []scriptStep{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxScriptLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		step := scriptStep{Line: line}
+		if err := json.Unmarshal(scanner.Bytes(), &step.auditEntry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// requests refused by the server changed nothing
+		if step.Error != "" {
+			continue
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+func writeScript(file string, entries []auditEntry) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, entry := range entries {
+		if entry.Error != "" {
+			continue
+		}
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, buf.Bytes(), 0o600)
+}
+
+// Replay applies the steps in a single transaction; if any step fails, or on
+// a dry run, nothing is changed.
+func Replay(steps []scriptStep, dryRun bool) ([]replayResult, error) {
+	results := []replayResult{}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		changed := []Path{}
+		for _, step := range steps {
+			from, to := replayPaths(step.auditEntry)
+			result := replayResult{Line: step.Line, Op: step.Op, Path: displayPath(from)}
+			result.old = snapshotAt(from, tx)
+			skipped, err := applyStep(step.auditEntry, changed, tx)
+			if err != nil {
+				result.Status = err.Error()
+				results = append(results, result)
+				return fmt.Errorf("line %d: %s %s: %w", step.Line, step.Op, result.Path, err)
+			}
+			result.Status = "ok"
+			if skipped {
+				result.Status = "skipped"
+			}
+			result.new = snapshotAt(to, tx)
+			results = append(results, result)
+			changed = append(changed, from, to)
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return results, nil
+	}
+	if err != nil {
+		return results, err
+	}
+	changes := []txChange{}
+	for i, step := range steps {
+		from, to := replayPaths(step.auditEntry)
+		changes = append(changes, txChange{op: step.Op, from: from, to: to, old: results[i].old})
+		entry := auditEntry{
+			Op:     step.Op,
+			Path:   auditPath(from),
+			Detail: "replayed line " + strconv.Itoa(step.Line),
+			Old:    results[i].old,
+			New:    results[i].new,
+		}
+		if to != nil && !slices.EqualFunc(to, from, bytes.Equal) {
+			entry.To = auditPath(to)
+		}
+		writeAudit(entry)
+	}
+	followChanges(dbFile, schemas, compiled, changes)
+	return results, nil
+}
+
+// replayPaths are the item a step acts on and the item it leaves behind, nil
+// if it is deleted.
+func replayPaths(step auditEntry) (Path, Path) {
+	from := Path(step.Path)
+	switch step.Op {
+	case "delete bucket", "delete key", "delete", "move to database":
+		return from, nil
+	}
+	if step.To != nil {
+		return from, Path(step.To)
+	}
+	return from, from
+}
+
+// applyStep checks that the database matches the state recorded in the step
+// before changing it; skipped is true for steps that do not change this
+// database. changed are the paths of the earlier steps.
+func applyStep(step auditEntry, changed []Path, tx *bbolt.Tx) (bool, error) {
+	path, to := replayPaths(step)
+	switch step.Op {
+	case "create bucket":
+		if err := expectState(path, nil, false, tx); err != nil {
+			return false, err
+		}
+		if len(path) == 1 {
+			_, err := tx.CreateBucket(path[0])
+			return false, err
+		}
+		// the parent was created by an earlier step, if it was created at all
+		parent, err := getBucket(parentPath(path), tx)
+		if err != nil {
+			return false, fmt.Errorf("%s does not exist: %w", displayPath(parentPath(path)), errReplayState)
+		}
+		_, err = parent.CreateBucket(path[len(path)-1])
+		return false, err
+	case "create key", "update key":
+		if len(path) < 2 { //nolint:mnd //keys are always inside a bucket
+			return false, errKeyAtRoot
+		}
+		if err := expectState(path, step.Old, step.Op == "update key", tx); err != nil {
+			return false, err
+		}
+		value, err := step.New.bytes()
+		if err != nil {
+			return false, err
+		}
+		if err := validateValue(parentPath(path), value); err != nil {
+			return false, err
+		}
+		parent, err := getBucket(parentPath(path), tx)
+		if err != nil {
+			return false, fmt.Errorf("%s does not exist: %w", displayPath(parentPath(path)), errReplayState)
+		}
+		return false, parent.Put(path[len(path)-1], value)
+	case "delete bucket", "delete key", "delete", "move to database":
+		if err := expectState(path, step.Old, true, tx); err != nil {
+			return false, err
+		}
+		node, err := nodeAt(path, tx)
+		if err != nil {
+			return false, err
+		}
+		if node.IsBucket {
+			return false, deleteBucket(path, tx)
+		}
+		return false, deleteKey(path, tx)
+	case "copy to database":
+		return true, expectState(path, step.Old, true, tx)
+	case "rename bucket", "rename key", "move bucket", "move key", "move",
+		"copy bucket", "copy key", "copy":
+		if err := expectState(path, step.Old, true, tx); err != nil {
+			return false, err
+		}
+		if err := expectState(to, nil, false, tx); err != nil {
+			return false, err
+		}
+		node, err := nodeAt(path, tx)
+		if err != nil {
+			return false, err
+		}
+		move := !slices.Contains([]string{"copy bucket", "copy key", "copy"}, step.Op)
+		// bbolt moves buckets as last committed, so buckets changed by
+		// earlier steps are copied and deleted instead
+		if move && node.IsBucket && slices.ContainsFunc(changed, func(p Path) bool {
+			return slices.EqualFunc(p, path, bytes.Equal) || isInside(p, path)
+		}) {
+			if err := transferItem(node, to, false, tx); err != nil {
+				return false, err
+			}
+			return false, deleteBucket(path, tx)
+		}
+		return false, transferItem(node, to, move, tx)
+	case "empty bucket", "set sequence", "delete range":
+		if err := expectState(path, step.Old, true, tx); err != nil {
+			return false, err
+		}
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return false, err
+		}
+		return false, changeBucket(step, bucket)
+	}
+	return false, fmt.Errorf("%w: %q", errReplayOp, step.Op)
+}
+
+func changeBucket(step auditEntry, bucket *bbolt.Bucket) error {
+	switch step.Op {
+	case "set sequence":
+		if step.New == nil {
+			return errReplayValue
+		}
+		return bucket.SetSequence(step.New.Sequence)
+	case "delete range":
+		r := keyRange{}
+		if _, err := fmt.Sscanf(step.Detail, "prefix=%q start=%q end=%q", &r.Prefix, &r.Start, &r.End); err != nil {
+			return fmt.Errorf("%w: range %q", errReplayOp, step.Detail)
+		}
+		if err := r.validate(); err != nil {
+			return err
+		}
+		for _, k := range rangeKeys(bucket, r, 0) {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return bucket.DeleteBucket(k)
+		}
+		return bucket.Delete(k)
+	})
+}
+
+// expectState checks the item at path against the recorded value: if exists
+// is false there must be nothing at path. Keys are compared by value hash;
+// buckets only by kind, as their contents usually differ between copies of a
+// database.
+func expectState(path Path, recorded *auditValue, exists bool, tx *bbolt.Tx) error {
+	node, err := nodeAt(path, tx)
+	switch {
+	case !exists && err == nil:
+		return fmt.Errorf("%s already exists: %w", displayPath(path), errReplayState)
+	case !exists:
+		return nil
+	case err != nil:
+		return fmt.Errorf("%s does not exist: %w", displayPath(path), errReplayState)
+	case recorded == nil:
+		return nil
+	case node.IsBucket != recorded.Bucket:
+		return fmt.Errorf("%s is not a %s: %w", displayPath(path), itemKind(recorded.Bucket), errReplayState)
+	case node.IsBucket || recorded.SHA256 == "":
+		return nil
+	}
+	sum := sha256.Sum256(node.Value)
+	if hex.EncodeToString(sum[:]) != recorded.SHA256 {
+		return fmt.Errorf("%s has a different value: %w", displayPath(path), errReplayState)
+	}
+	return nil
+}
+
+func itemKind(bucket bool) string {
+	if bucket {
+		return "bucket"
+	}
+	return "key"
+}
+
+func (v *auditValue) bytes() ([]byte, error) {
+	if v == nil || (v.Value == "" && v.Size > 0) {
+		return nil, errReplayValue
+	}
+	if v.Base64 {
+		return base64.StdEncoding.DecodeString(v.Value)
+	}
+	return []byte(v.Value), nil
+}
+
+func replayCommand(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "check every step without changing the database")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 { //nolint:mnd //dbfile and script
+		return errUsage
+	}
+	f, err := os.Open(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	steps, err := readScript(f)
+	if err != nil {
+		return err
+	}
+	if err := openDB(flags.Arg(0)); err != nil {
+		return err
+	}
+	defer closeDB()
+	results, err := Replay(steps, *dryRun)
+	for _, result := range results {
+		fmt.Printf("line %d: %s %s: %s\n", result.Line, result.Op, result.Path, result.Status)
+	}
+	if err != nil {
+		fmt.Println("nothing was changed")
+		return err
+	}
+	if *dryRun {
+		fmt.Println(len(results), "steps can be applied")
+	} else {
+		fmt.Println(len(results), "steps applied")
+	}
+	return nil
+}
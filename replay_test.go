@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// a bucket changed by earlier steps keeps those changes when it is moved
+func TestReplayMoveChanged(t *testing.T) {
+	openTestDB(t)
+	script := `{"op":"create bucket","path":["a"]}
+{"op":"create bucket","path":["b"]}
+{"op":"create key","path":["a","k"],"new":{"size":1,"sha256":"","value":"v"}}
+{"op":"move bucket","path":["a"],"to":["b","a"],"old":{"bucket":true}}
+`
+	steps, err := readScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Replay(steps, false); err != nil {
+		t.Fatal(err)
+	}
+	node, err := currentNode(Path{[]byte("b"), []byte("a"), []byte("k")})
+	if err != nil || string(node.Value) != "v" {
+		t.Errorf("moved key is %q, %v", node.Value, err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.etcd.io/bbolt"
+)
+
+const schemaURL = "schema.json"
+
+var (
+	// schemaMu guards schemas and the compiled cache, which validateValue
+	// fills in from server handlers and scripts
+	schemaMu       sync.Mutex
+	schemas        = make(map[string]json.RawMessage)
+	compiled       = make(map[string]*jsonschema.Schema)
+	errNotJSON     = errors.New("value is not valid json")
+	errSchemaValue = errors.New("value does not match bucket schema")
+)
+
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+type schemaError struct {
+	Fields []fieldError
+}
+
+func (e *schemaError) Error() string {
+	lines := []string{}
+	for _, field := range e.Fields {
+		lines = append(lines, field.Field+": "+field.Message)
+	}
+	return errSchemaValue.Error() + "\n" + strings.Join(lines, "\n")
+}
+
+func (e *schemaError) Unwrap() error {
+	return errSchemaValue
+}
+
+type keyReport struct {
+	Key    []byte
+	Errors string
+}
+
+// schemas are kept next to the database so the database itself is untouched
+func schemaFile() string {
+	return schemaFileFor(dbFile)
+}
+
+func schemaFileFor(file string) string {
+	return file + ".schemas.json"
+}
+
+// schemas are keyed by the escaped path of their bucket, as in urls, so
+// names holding "/" or binary data cannot be confused
+func schemaKey(bucket Path) string {
+	return escapePath(bucket)
+}
+
+func loadSchemas() error {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	schemas = make(map[string]json.RawMessage)
+	compiled = make(map[string]*jsonschema.Schema)
+	data, err := os.ReadFile(schemaFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	for name, schema := range saved {
+		// files written before paths were escaped hold "/" separated names
+		if path, err := unescapePath(name); err != nil || schemaKey(path) != name {
+			name = schemaKey(stringToPath(name))
+		}
+		schemas[name] = schema
+	}
+	return nil
+}
+
+func saveSchemas() error {
+	return saveSchemasTo(dbFile, schemas)
+}
+
+func saveSchemasTo(file string, schemaSet map[string]json.RawMessage) error {
+	if len(schemaSet) == 0 {
+		if err := os.Remove(schemaFileFor(file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(schemaSet, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(schemaFileFor(file), data, 0o600)
+}
+
+// followChanges moves the schemas of moved and renamed buckets, and of the
+// buckets within them, and drops those of deleted buckets, once changes to
+// file are committed.
+func followChanges(file string, schemaSet map[string]json.RawMessage, cache map[string]*jsonschema.Schema, changes []txChange) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	changed := false
+	for _, change := range changes {
+		if change.old == nil || !change.old.Bucket {
+			continue
+		}
+		from := schemaKey(change.from)
+		moved := map[string]json.RawMessage{}
+		for name, schema := range schemaSet {
+			inside := strings.HasPrefix(name, from+"/")
+			if name != from && !inside {
+				continue
+			}
+			switch change.op {
+			case "delete bucket", "delete", "move to database":
+			case "rename bucket", "move bucket", "move":
+				moved[schemaKey(change.to)+strings.TrimPrefix(name, from)] = schema
+			case "empty bucket":
+				if !inside {
+					continue
+				}
+			default:
+				continue
+			}
+			delete(schemaSet, name)
+			delete(cache, name)
+			changed = true
+		}
+		maps.Copy(schemaSet, moved)
+	}
+	if changed {
+		if err := saveSchemasTo(file, schemaSet); err != nil {
+			log.Println("save schemas", err)
+		}
+	}
+}
+
+func compileSchema(schema []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(schemaURL, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(schemaURL)
+}
+
+func getSchema(bucket Path) ([]byte, bool) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	schema, ok := schemas[schemaKey(bucket)]
+	return schema, ok
+}
+
+// SetSchema attaches a JSON Schema to a bucket; an empty schema removes it.
+func SetSchema(bucket Path, schema []byte) error {
+	if db != nil {
+		if err := db.View(func(tx *bbolt.Tx) error { return checkLoaded(bucket, tx) }); err != nil {
+			return err
+		}
+	}
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	name := schemaKey(bucket)
+	delete(compiled, name)
+	if len(bytes.TrimSpace(schema)) == 0 {
+		delete(schemas, name)
+		return saveSchemas()
+	}
+	sch, err := compileSchema(schema)
+	if err != nil {
+		return err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, schema); err != nil {
+		return err
+	}
+	log.Println("set schema", name)
+	schemas[name] = compact.Bytes()
+	compiled[name] = sch
+	return saveSchemas()
+}
+
+func validateValue(bucket Path, value []byte) error {
+	return validateValueIn(schemas, compiled, bucket, value)
+}
+
+// validateValueIn checks value against the schemas of a session, for scripts
+// which keep running while another tab is selected.
+func validateValueIn(schemaSet map[string]json.RawMessage, cache map[string]*jsonschema.Schema, bucket Path, value []byte) error {
+	sch, err := bucketSchema(schemaSet, cache, bucket)
+	if sch == nil {
+		return err
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(value))
+	if err != nil {
+		return errNotJSON
+	}
+	err = sch.Validate(inst)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	result := &schemaError{}
+	for _, unit := range validationErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		field := unit.InstanceLocation
+		if field == "" {
+			field = "/"
+		}
+		result.Fields = append(result.Fields, fieldError{Field: field, Message: unit.Error.String()})
+	}
+	return result
+}
+
+// bucketSchema returns the compiled schema of a bucket, or nil if it has
+// none.
+func bucketSchema(schemaSet map[string]json.RawMessage, cache map[string]*jsonschema.Schema, bucket Path) (*jsonschema.Schema, error) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	name := schemaKey(bucket)
+	schema, ok := schemaSet[name]
+	if !ok {
+		return nil, nil //nolint:nilnil //no schema
+	}
+	sch, ok := cache[name]
+	if !ok {
+		var err error
+		if sch, err = compileSchema(schema); err != nil {
+			return nil, fmt.Errorf("bucket schema: %w", err)
+		}
+		cache[name] = sch
+	}
+	return sch, nil
+}
+
+func ValidateBucket(path Path) ([]keyReport, error) {
+	if _, ok := getSchema(path); !ok {
+		return nil, fmt.Errorf("%s: no schema", pathToString(path))
+	}
+	reports := []keyReport{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			err := validateValue(path, v)
+			if err == nil {
+				return nil
+			}
+			var se *schemaError
+			if !errors.As(err, &se) && !errors.Is(err, errNotJSON) {
+				return err
+			}
+			reports = append(reports, keyReport{Key: bytes.Clone(k), Errors: err.Error()})
+			return nil
+		})
+	})
+	return reports, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := openDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+}
+
+func TestSchemaFollowsBucket(t *testing.T) {
+	openTestDB(t)
+	slash, nested := Path{[]byte("a/b")}, Path{[]byte("a"), []byte("b")}
+	for _, path := range []Path{slash, nested} {
+		if _, err := CreateBucket(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetSchema(slash, []byte(`{"type": "object"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getSchema(nested); ok {
+		t.Error("a/b has the schema of the bucket named a/b")
+	}
+	if err := CreateKey([]byte("k"), []byte(`[]`), slash); !errors.Is(err, errSchemaValue) {
+		t.Errorf("invalid value: %v", err)
+	}
+	node, err := currentNode(slash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := Path{[]byte("c")}
+	if err := RenameBucket(node, renamed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getSchema(slash); ok {
+		t.Error("schema left behind by the rename")
+	}
+	if _, ok := getSchema(renamed); !ok {
+		t.Error("schema not moved by the rename")
+	}
+	if err := loadSchemas(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getSchema(renamed); !ok {
+		t.Error("moved schema not saved")
+	}
+	if node, err = currentNode(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBucket(node); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getSchema(renamed); ok {
+		t.Error("schema of a deleted bucket kept")
+	}
+}
+
+// schema files written before paths were escaped are still read
+func TestLegacySchemas(t *testing.T) {
+	openTestDB(t)
+	if err := os.WriteFile(schemaFile(), []byte(`{"users/new accounts": {"type": "object"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSchemas(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getSchema(Path{[]byte("users"), []byte("new accounts")}); !ok {
+		t.Error("legacy schema not found")
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.etcd.io/bbolt"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+var (
+	errScriptFailed = errors.New("script failed")
+	errScriptValue  = errors.New("value must be a string or bytes")
+	errScriptRace   = errors.New("the database was changed while the script ran, run it again")
+)
+
+// scripts may use while loops, recursion and statements at the top level
+var scriptOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+const (
+	// scripts are stopped after this many steps or this long, so a runaway
+	// loop does not keep the database busy
+	maxScriptSteps = 100_000_000
+	scriptTimeout  = 10 * time.Minute
+)
+
+var (
+	scriptsMu      sync.Mutex
+	runningScripts = map[*scriptRun]bool{}
+)
+
+// scriptRun holds the database of a script, which is kept when another tab
+// is selected while it runs, the state the script sees and the changes to
+// apply once it succeeds.
+type scriptRun struct {
+	db       *bbolt.DB
+	file     string
+	schemas  map[string]json.RawMessage
+	compiled map[string]*jsonschema.Schema
+	thread   *starlark.Thread
+	state    *scriptState
+	pending  []func(tx *bbolt.Tx) error
+	changes  []txChange
+}
+
+// newScriptRun prepares a script on the current database; printed lines are
+// passed to output.
+func newScriptRun(name string, output func(string)) *scriptRun {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			output(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	return &scriptRun{db: db, file: dbFile, schemas: schemas, compiled: compiled, thread: thread}
+}
+
+// stop cancels the script; it may be called from another goroutine.
+func (r *scriptRun) stop() {
+	r.thread.Cancel("stopped")
+}
+
+// stopScripts stops the scripts running on database, before it is closed.
+func stopScripts(database *bbolt.DB) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	for r := range runningScripts {
+		if r.db == database {
+			r.stop()
+		}
+	}
+}
+
+// RunScript runs a Starlark script and returns the number of changes made;
+// if the script fails, or on a dry run, nothing is changed. Printed lines are
+// passed to output.
+func RunScript(name, src string, dryRun bool, output func(string)) (int, error) {
+	return newScriptRun(name, output).exec(src, dryRun)
+}
+
+// exec runs the script on a read transaction and then applies its changes
+// in one short update, provided nothing else changed the database meanwhile.
+func (r *scriptRun) exec(src string, dryRun bool) (int, error) {
+	name := r.thread.Name
+	scriptsMu.Lock()
+	runningScripts[r] = true
+	scriptsMu.Unlock()
+	defer func() {
+		scriptsMu.Lock()
+		delete(runningScripts, r)
+		scriptsMu.Unlock()
+	}()
+	timer := time.AfterFunc(scriptTimeout, func() {
+		r.thread.Cancel("timed out")
+	})
+	defer timer.Stop()
+	start := 0
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		start = tx.ID()
+		r.state = newScriptState(tx)
+		_, err := starlark.ExecFileOptions(scriptOptions, r.thread, name, src, r.builtins())
+		return err
+	})
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return 0, fmt.Errorf("%w: %s", errScriptFailed, evalErr.Backtrace())
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		// update transactions follow the last commit
+		if tx.ID() != start+1 {
+			return errScriptRace
+		}
+		for _, change := range r.pending {
+			if err := change(tx); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return len(r.changes), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	writeChangesTo(r.file, r.changes, "script "+name)
+	followChanges(r.file, r.schemas, r.compiled, r.changes)
+	return len(r.changes), nil
+}
+
+func (r *scriptRun) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"get":          starlark.NewBuiltin("get", r.get),
+		"put":          starlark.NewBuiltin("put", r.put),
+		"delete":       starlark.NewBuiltin("delete", r.delete),
+		"forEach":      starlark.NewBuiltin("forEach", r.forEach),
+		"createBucket": starlark.NewBuiltin("createBucket", r.createBucket),
+		"move":         starlark.NewBuiltin("move", r.move),
+		"json":         starlarkjson.Module,
+	}
+}
+
+// apply queues change for the update made once the script succeeds.
+func (r *scriptRun) apply(path string, change func(tx *bbolt.Tx) error) {
+	r.pending = append(r.pending, func(tx *bbolt.Tx) error {
+		if err := change(tx); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// record runs change and keeps the item at from before and at to after it.
+func (r *scriptRun) record(op string, from, to Path, tx *bbolt.Tx, change func() error) error {
+	old := snapshotAt(from, tx)
+	if err := change(); err != nil {
+		return err
+	}
+	r.changes = append(r.changes, txChange{op: op, from: from, to: to, old: old, new: snapshotAt(to, tx)})
+	return nil
+}
+
+// createMissing creates the missing buckets of path within tx and records
+// them.
+func (r *scriptRun) createMissing(path Path, tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	bucket, created, err := createMissing(path, tx)
+	if err != nil {
+		return nil, err
+	}
+	r.changes = append(r.changes, createdBuckets(created, tx)...)
+	return bucket, nil
+}
+
+// get(path) returns the value of a key, or None if there is no such key.
+func (r *scriptRun) get(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+	item, ok := r.state.lookup(stringToPath(path))
+	if !ok || item.bucket {
+		return starlark.None, nil
+	}
+	return starlark.String(item.value), nil
+}
+
+// put(path, value) creates or updates a key, creating missing buckets.
+func (r *scriptRun) put(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	var v starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path, "value", &v); err != nil {
+		return nil, err
+	}
+	value, ok := scriptBytes(v)
+	if !ok {
+		return nil, fmt.Errorf("%w, got %s", errScriptValue, v.Type())
+	}
+	p := stringToPath(path)
+	if len(p) < 2 { //nolint:mnd //keys are always inside a bucket
+		return nil, fmt.Errorf("%s: %w", path, errKeyAtRoot)
+	}
+	if len(p[len(p)-1]) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, bbolt.ErrKeyRequired)
+	}
+	op := "create key"
+	if item, ok := r.state.lookup(p); ok {
+		if item.bucket {
+			return nil, fmt.Errorf("%s: %w", path, errNotKey)
+		}
+		op = "update key"
+	}
+	if err := validateValueIn(r.schemas, r.compiled, parentPath(p), value); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := r.state.createMissing(parentPath(p)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.state.set(scriptItem{path: p, value: value})
+	r.apply(path, func(tx *bbolt.Tx) error {
+		return r.record(op, p, p, tx, func() error {
+			parent, err := r.createMissing(parentPath(p), tx)
+			if err != nil {
+				return err
+			}
+			return parent.Put(p[len(p)-1], value)
+		})
+	})
+	return starlark.None, nil
+}
+
+// delete(path) deletes a key or bucket and reports whether it existed.
+func (r *scriptRun) delete(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+	p := stringToPath(path)
+	item, ok := r.state.lookup(p)
+	if !ok {
+		return starlark.False, nil
+	}
+	op := "delete key"
+	if item.bucket {
+		op = "delete bucket"
+	}
+	r.state.remove(p)
+	r.apply(path, func(tx *bbolt.Tx) error {
+		return r.record(op, p, nil, tx, func() error {
+			if item.bucket {
+				return deleteBucket(p, tx)
+			}
+			return deleteKey(p, tx)
+		})
+	})
+	return starlark.True, nil
+}
+
+// forEach(bucket, fn) calls fn(key, value) for the contents of bucket, with
+// value None for nested buckets, until fn returns False. The contents are
+// read before the first call, so fn may change the bucket.
+func (r *scriptRun) forEach(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "bucket", &path, "fn", &fn); err != nil {
+		return nil, err
+	}
+	p := stringToPath(path)
+	if bucket, ok := r.state.lookup(p); !ok || !bucket.bucket {
+		return nil, fmt.Errorf("%s: %w", path, errInvalidPath)
+	}
+	items := []starlark.Tuple{}
+	for _, item := range r.state.list(p) {
+		var value starlark.Value = starlark.None
+		if !item.bucket {
+			value = starlark.String(item.value)
+		}
+		items = append(items, starlark.Tuple{starlark.String(item.path[len(item.path)-1]), value})
+	}
+	for _, item := range items {
+		result, err := starlark.Call(thread, fn, item, nil)
+		if err != nil {
+			return nil, err
+		}
+		if result == starlark.False {
+			break
+		}
+	}
+	return starlark.None, nil
+}
+
+// createBucket(path) creates a bucket and its parents and reports whether it
+// was created.
+func (r *scriptRun) createBucket(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+	p := stringToPath(path)
+	if item, ok := r.state.lookup(p); ok {
+		if !item.bucket {
+			return nil, fmt.Errorf("%s: %w", path, errNotBucket)
+		}
+		return starlark.False, nil
+	}
+	if _, err := r.state.createMissing(p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.apply(path, func(tx *bbolt.Tx) error {
+		_, err := r.createMissing(p, tx)
+		return err
+	})
+	return starlark.True, nil
+}
+
+// move(from, to) moves a key or bucket.
+func (r *scriptRun) move(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var from, to string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "from", &from, "to", &to); err != nil {
+		return nil, err
+	}
+	src, dst := stringToPath(from), stringToPath(to)
+	item, err := r.checkMove(src, dst)
+	if err != nil {
+		return nil, fmt.Errorf("%s to %s: %w", from, to, err)
+	}
+	op := "move key"
+	if item.bucket {
+		op = "move bucket"
+	}
+	// bbolt moves buckets as last committed, so buckets the script changed
+	// are copied and deleted instead
+	copied := item.bucket && r.state.changed(src)
+	r.state.copyItem(item, dst)
+	r.state.remove(src)
+	node := TreeNode{Path: src, IsBucket: item.bucket, Value: item.value}
+	r.apply(from+" to "+to, func(tx *bbolt.Tx) error {
+		if _, err := r.createMissing(parentPath(dst), tx); err != nil {
+			return err
+		}
+		return r.record(op, src, dst, tx, func() error {
+			if !copied {
+				return transferItem(node, dst, true, tx)
+			}
+			if err := transferItem(node, dst, false, tx); err != nil {
+				return err
+			}
+			return deleteBucket(src, tx)
+		})
+	})
+	return starlark.None, nil
+}
+
+// checkMove returns the item at src if it can be moved to dst, adding the
+// missing parents of dst.
+func (r *scriptRun) checkMove(src, dst Path) (scriptItem, error) {
+	item, ok := r.state.lookup(src)
+	switch {
+	case !ok:
+		return item, errInvalidPath
+	case len(dst) == 0:
+		return item, errInvalidPath
+	case !item.bucket && len(dst) < 2: //nolint:mnd //keys are always inside a bucket
+		return item, errKeyAtRoot
+	case item.bucket && isInside(dst, src):
+		return item, errInsideSource
+	}
+	if existing, ok := r.state.lookup(dst); ok {
+		switch {
+		case existing.bucket && item.bucket:
+			return item, errBucketExists
+		case !existing.bucket && !item.bucket:
+			return item, errKeyExists
+		}
+		return item, bbolt.ErrIncompatibleValue
+	}
+	if len(dst[len(dst)-1]) == 0 {
+		return item, errInvalidPath
+	}
+	if _, err := r.state.createMissing(parentPath(dst)); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+func scriptBytes(v starlark.Value) ([]byte, bool) {
+	switch v := v.(type) {
+	case starlark.String:
+		return []byte(v), true
+	case starlark.Bytes:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
+func scriptCommand(args []string) error {
+	flags := flag.NewFlagSet("script", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "run the script without changing the database")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 { //nolint:mnd //dbfile and script
+		return errUsage
+	}
+	src, err := os.ReadFile(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := openDB(flags.Arg(0)); err != nil {
+		return err
+	}
+	defer closeDB()
+	changes, err := RunScript(flags.Arg(1), string(src), *dryRun, func(msg string) {
+		fmt.Println(msg)
+	})
+	if err != nil {
+		fmt.Println("nothing was changed")
+		return err
+	}
+	if *dryRun {
+		fmt.Println(changes, "changes would be made, nothing was changed")
+	} else {
+		fmt.Println(changes, "changes made")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestScriptChanges(t *testing.T) {
+	openTestDB(t)
+	if err := CreateKey([]byte("alice"), []byte(`{"age":30}`), Path{[]byte("users")}); err != nil {
+		t.Fatal(err)
+	}
+	src := `
+put("users/bob", json.encode({"age": 40}))
+def bump(key, value):
+    user = json.decode(value)
+    user["age"] += 1
+    put("users/" + key, json.encode(user))
+forEach("users", bump)
+move("users", "archive/users")
+keys = []
+forEach("archive/users", lambda key, value: keys.append(key))
+print(keys, get("archive/users/bob"), get("users/alice"))
+`
+	output := []string{}
+	run := func(dryRun bool) int {
+		t.Helper()
+		output = output[:0]
+		changes, err := RunScript("test.star", src, dryRun, func(msg string) {
+			output = append(output, msg)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes
+	}
+	// bob, two updates, the archive bucket and the move
+	if changes := run(true); changes != 5 {
+		t.Errorf("%d changes on a dry run", changes)
+	}
+	if _, err := currentNode(Path{[]byte("archive")}); err == nil {
+		t.Error("dry run changed the database")
+	}
+	if changes := run(false); changes != 5 {
+		t.Errorf("%d changes", changes)
+	}
+	if want := `["alice", "bob"] {"age":41} None`; strings.Join(output, "\n") != want {
+		t.Errorf("output %q, want %q", output, want)
+	}
+	node, err := currentNode(Path{[]byte("archive"), []byte("users"), []byte("alice")})
+	if err != nil || string(node.Value) != `{"age":31}` {
+		t.Errorf("alice is %s, %v", node.Value, err)
+	}
+}
+
+// changes made while the script runs are not overwritten
+func TestScriptRace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	if err := openDBWith(file, &bbolt.Options{InitialMmapSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+	_, err := RunScript("test.star", `print("x")`+"\n"+`put("a/k", "v")`, false, func(string) {
+		if _, err := CreateBucket(Path{[]byte("b")}); err != nil {
+			t.Error(err)
+		}
+	})
+	if !errors.Is(err, errScriptRace) {
+		t.Errorf("error %v", err)
+	}
+	if _, err := currentNode(Path{[]byte("a")}); err == nil {
+		t.Error("script changes were made")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// scriptState is the database as a running script sees it: a read
+// transaction with the script's changes laid over it, so the script can run
+// without holding the write transaction. Buckets in the overlay replace
+// whatever the database holds at their path.
+type scriptState struct {
+	tx    *bbolt.Tx
+	items map[string]*scriptItem
+	// escaped paths of the overlay items within each bucket
+	children map[string]map[string]bool
+}
+
+type scriptItem struct {
+	path    Path
+	deleted bool
+	bucket  bool
+	value   []byte
+}
+
+func newScriptState(tx *bbolt.Tx) *scriptState {
+	return &scriptState{tx: tx, items: map[string]*scriptItem{}, children: map[string]map[string]bool{}}
+}
+
+// lookup returns the item at path, if there is one.
+func (s *scriptState) lookup(path Path) (scriptItem, bool) {
+	if len(path) == 0 {
+		return scriptItem{}, false
+	}
+	if item, ok := s.items[escapePath(path)]; ok {
+		return *item, !item.deleted
+	}
+	if s.replaced(parentPath(path)) {
+		return scriptItem{}, false
+	}
+	if _, err := getBucket(path, s.tx); err == nil {
+		return scriptItem{path: path, bucket: true}, true
+	}
+	if len(path) < 2 { //nolint:mnd //keys are always inside a bucket
+		return scriptItem{}, false
+	}
+	parent, err := getParentBucket(path, s.tx)
+	if err != nil {
+		return scriptItem{}, false
+	}
+	value, ok := getValue(parent, path[len(path)-1])
+	if !ok {
+		return scriptItem{}, false
+	}
+	return scriptItem{path: path, value: bytes.Clone(value)}, true
+}
+
+// replaced reports whether path or one of its parents is in the overlay, so
+// the database no longer shows through.
+func (s *scriptState) replaced(path Path) bool {
+	for i := len(path); i > 0; i-- {
+		if _, ok := s.items[escapePath(path[:i])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// changed reports whether the script changed anything at or within path.
+func (s *scriptState) changed(path Path) bool {
+	key := escapePath(path)
+	for name := range s.items {
+		if name == key || strings.HasPrefix(name, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scriptState) set(item scriptItem) {
+	parent := escapePath(parentPath(item.path))
+	if s.children[parent] == nil {
+		s.children[parent] = map[string]bool{}
+	}
+	key := escapePath(item.path)
+	s.items[key] = &item
+	s.children[parent][key] = true
+}
+
+// forget drops the overlay items within path.
+func (s *scriptState) forget(path Path) {
+	key := escapePath(path)
+	for child := range s.children[key] {
+		s.forget(s.items[child].path)
+		delete(s.items, child)
+	}
+	delete(s.children, key)
+}
+
+func (s *scriptState) remove(path Path) {
+	s.forget(path)
+	s.set(scriptItem{path: path, deleted: true})
+}
+
+// list returns the items of a bucket in key order.
+func (s *scriptState) list(path Path) []scriptItem {
+	items := map[string]scriptItem{}
+	if !s.replaced(path) {
+		if bucket, err := getBucket(path, s.tx); err == nil {
+			bucket.ForEach(func(k, v []byte) error { //nolint:gosec // no errors returned
+				items[string(k)] = scriptItem{path: childPath(path, k), bucket: v == nil, value: bytes.Clone(v)}
+				return nil
+			})
+		}
+	}
+	for child := range s.children[escapePath(path)] {
+		item := s.items[child]
+		name := string(item.path[len(item.path)-1])
+		if item.deleted {
+			delete(items, name)
+		} else {
+			items[name] = *item
+		}
+	}
+	list := []scriptItem{}
+	for _, name := range slices.Sorted(maps.Keys(items)) {
+		list = append(list, items[name])
+	}
+	return list
+}
+
+// createMissing adds the buckets of path which do not exist yet and returns
+// their paths, parents first.
+func (s *scriptState) createMissing(path Path) ([]Path, error) {
+	created := []Path{}
+	for i := range path {
+		if len(path[i]) == 0 {
+			return nil, bbolt.ErrBucketNameRequired
+		}
+		item, ok := s.lookup(path[:i+1])
+		switch {
+		case !ok:
+			s.set(scriptItem{path: slices.Clone(path[:i+1]), bucket: true})
+			created = append(created, slices.Clone(path[:i+1]))
+		case !item.bucket:
+			return nil, bbolt.ErrIncompatibleValue
+		}
+	}
+	return created, nil
+}
+
+// copyItem adds item, and for buckets everything in it, at path.
+func (s *scriptState) copyItem(item scriptItem, path Path) {
+	children := []scriptItem{}
+	if item.bucket {
+		children = s.list(item.path)
+	}
+	s.set(scriptItem{path: path, bucket: item.bucket, value: item.value})
+	for _, child := range children {
+		s.copyItem(child, childPath(path, child.path[len(child.path)-1]))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/text/textcore"
+)
+
+var errScriptName = errors.New("script name must be a file name")
+
+const scriptExample = `# get(path), put(path, value), delete(path), createBucket(path),
+# move(from, to), forEach(bucket, fn) and json.encode/json.decode
+# paths are "/" separated, e.g. "users/alice"
+
+def show(key, value):
+    print(key, value)
+
+forEach("users", show)
+`
+
+// saved scripts are shared by all databases
+func scriptsDir() string {
+	return filepath.Join(core.TheApp.AppDataDir(), "scripts")
+}
+
+func savedScripts() []string {
+	scripts := []string{}
+	entries, err := os.ReadDir(scriptsDir())
+	if err != nil {
+		return scripts
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".star" {
+			scripts = append(scripts, entry.Name())
+		}
+	}
+	slices.Sort(scripts)
+	return scripts
+}
+
+func saveScript(name string, src []byte) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || filepath.Base(name) != name {
+		return "", errScriptName
+	}
+	if filepath.Ext(name) != ".star" {
+		name += ".star"
+	}
+	if err := os.MkdirAll(scriptsDir(), 0o700); err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(filepath.Join(scriptsDir(), name), src, 0o600)
+}
+
+// scriptDialog runs scripts on the database of the current tab, in the
+// background so a long script can be stopped.
+func scriptDialog(button *core.Button) {
+	title := "Script Console"
+	s := current
+	d := core.NewBody(title)
+	core.NewText(d).SetText("Run a Starlark script on " + dbFile + "; nothing is changed unless the script succeeds, then all its changes are made at once")
+	bar := core.NewFrame(d)
+	core.NewText(bar).SetText("Saved")
+	saved := core.NewChooser(bar).SetStrings(savedScripts()...).SetPlaceholder("open a saved script")
+	core.NewText(bar).SetText("Name")
+	name := core.NewTextField(bar).SetText("script.star")
+	editor := textcore.NewEditor(d)
+	editor.Lines.SetText([]byte(scriptExample))
+	core.NewText(d).SetText("Output")
+	output := textcore.NewEditor(d)
+	output.SetReadOnly(true)
+	saved.OnChange(func(e events.Event) {
+		file, ok := saved.CurrentItem.Value.(string)
+		if !ok {
+			return
+		}
+		src, err := os.ReadFile(filepath.Join(scriptsDir(), file))
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		name.SetText(file).Update()
+		editor.Lines.SetText(src)
+	})
+	save := core.NewButton(bar).SetText("Save")
+	save.OnClick(func(e events.Event) {
+		file, err := saveScript(name.Text(), editor.Lines.Text())
+		if err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		name.SetText(file).Update()
+		saved.SetStrings(savedScripts()...).SetCurrentValue(file).Update()
+		core.MessageSnackbar(save, "saved "+file)
+	})
+	var running *scriptRun
+	var dryRunButton, runButton, stopButton *core.Button
+	setRunning := func(r *scriptRun) {
+		running = r
+		dryRunButton.SetEnabled(r == nil)
+		runButton.SetEnabled(r == nil)
+		stopButton.SetEnabled(r != nil)
+		for _, b := range []*core.Button{dryRunButton, runButton, stopButton} {
+			b.Update()
+		}
+	}
+	run := func(dryRun bool) {
+		// out is only written by the script goroutine until it finishes
+		out := bytes.Buffer{}
+		var r *scriptRun
+		if err := withSession(s, func() error {
+			r = newScriptRun(name.Text(), func(msg string) {
+				out.WriteString(msg + "\n")
+			})
+			return nil
+		}); err != nil {
+			core.ErrorDialog(d, err, title)
+			return
+		}
+		output.Lines.SetText([]byte("running...\n"))
+		setRunning(r)
+		src := string(editor.Lines.Text())
+		go func() {
+			changes, err := r.exec(src, dryRun)
+			app.AsyncLock()
+			defer app.AsyncUnlock()
+			switch {
+			case err != nil:
+				out.WriteString(err.Error() + "\nnothing was changed\n")
+			case dryRun:
+				out.WriteString(strconv.Itoa(changes) + " changes would be made, nothing was changed\n")
+			default:
+				out.WriteString(strconv.Itoa(changes) + " changes made\n")
+				if changes > 0 {
+					withSession(s, func() error { //nolint:errcheck,gosec //the tab was closed, nothing to reload
+						reload()
+						return nil
+					})
+				}
+			}
+			output.Lines.SetText(out.Bytes())
+			setRunning(nil)
+		}()
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar).SetText("Close").OnClick(func(e events.Event) {
+			if running != nil {
+				running.stop()
+			}
+		})
+		dryRunButton = core.NewButton(bar).SetText("Dry Run")
+		dryRunButton.OnClick(func(e events.Event) {
+			run(true)
+		})
+		runButton = core.NewButton(bar).SetText("Run")
+		runButton.OnClick(func(e events.Event) {
+			run(false)
+		})
+		stopButton = core.NewButton(bar).SetText("Stop")
+		stopButton.SetEnabled(false)
+		stopButton.OnClick(func(e events.Event) {
+			if running != nil {
+				running.stop()
+			}
+		})
+	})
+	d.RunWindowDialog(button)
+}
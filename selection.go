@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+)
+
+func selectedItems() []TreeNode {
+	nodes := []TreeNode{}
+	if dbTree == nil {
+		return nodes
+	}
+	for _, selected := range dbTree.GetSelectedNodes() {
+		node, ok := nodeMap[selected.AsCoreTree().Name]
+		if ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func selectBucketKeys(bucket TreeNode) {
+	dbTree.UnselectAll()
+	if item, ok := treeItems[pathToString(bucket.Path)]; ok {
+		item.SetClosed(false)
+	}
+	for _, child := range bucket.Children {
+		if child.IsBucket {
+			continue
+		}
+		if item, ok := treeItems[pathToString(child.Path)]; ok {
+			item.Select()
+		}
+	}
+	app.Update()
+}
+
+func selectMatching(text string) int {
+	dbTree.UnselectAll()
+	count := 0
+	for name, item := range treeItems {
+		if !strings.Contains(displayPath(nodeMap[name].Path), text) {
+			continue
+		}
+		// reveal matches inside closed buckets
+		revealItem(name)
+		item.Select()
+		count++
+	}
+	app.Update()
+	return count
+}
+
+func selectionContext(m *core.Scene, pos image.Point) {
+	button := core.NewButton(m).SetText("Delete Selected")
+	button.OnClick(func(e events.Event) {
+		deleteItemsDialog(selectedItems(), button)
+	})
+	core.NewButton(m).SetText("Move Selected").OnClick(func(e events.Event) {
+		transferItemsDialog(selectedItems(), true, button)
+	})
+	core.NewButton(m).SetText("Copy Selected").OnClick(func(e events.Event) {
+		transferItemsDialog(selectedItems(), false, button)
+	})
+	core.NewButton(m).SetText("Export Selected").OnClick(func(e events.Event) {
+		exportItemsDialog(selectedItems(), button)
+	})
+	core.NewButton(m).SetText("Select Matching").OnClick(func(e events.Event) {
+		selectMatchingDialog(button)
+	})
+	core.NewButton(m).SetText("Clear Selection").OnClick(func(e events.Event) {
+		dbTree.UnselectAll()
+		app.Update()
+	})
+}
+
+func itemList(d *core.Body, nodes []TreeNode) {
+	core.NewText(d).SetText(strconv.Itoa(len(nodes)) + " selected items")
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, displayPath(node.Path))
+	}
+	core.NewText(d).SetText(strings.Join(names, "\n"))
+}
+
+func deleteItemsDialog(nodes []TreeNode, button *core.Button) {
+	if len(nodes) == 0 {
+		core.MessageSnackbar(button, "nothing selected")
+		return
+	}
+	d := core.NewBody("Delete Selected")
+	itemList(d, nodes)
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			summary, err := DeleteItems(nodes)
+			if errors.Is(err, errConflict) {
+				conflictDialog(err, "Delete Selected", button, func() error {
+					current, err := currentNodes(nodes)
+					if err != nil {
+						return err
+					}
+					_, err = DeleteItems(current)
+					return err
+				}, nil)
+				return
+			}
+			if err != nil {
+				core.ErrorDialog(button, err, "Delete Selected")
+				return
+			}
+			reload()
+			core.MessageSnackbar(app, "deleted "+summary.String())
+		})
+	})
+	d.RunDialog(button)
+}
+
+func transferItemsDialog(nodes []TreeNode, move bool, button *core.Button) {
+	if len(nodes) == 0 {
+		core.MessageSnackbar(button, "nothing selected")
+		return
+	}
+	title := "Copy Selected"
+	if move {
+		title = "Move Selected"
+	}
+	d := core.NewBody(title)
+	itemList(d, nodes)
+	core.NewText(d).SetText("Destination Bucket")
+	dest := newBucketPicker(d, nil, true)
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			path, err := dest.Path()
+			if err != nil {
+				core.ErrorDialog(button, err, title)
+				return
+			}
+			transfer := CopyItems
+			if move {
+				transfer = MoveItems
+			}
+			summary, err := transfer(nodes, path)
+			if errors.Is(err, errConflict) {
+				conflictDialog(err, title, button, func() error {
+					current, err := currentNodes(nodes)
+					if err != nil {
+						return err
+					}
+					_, err = transfer(current, path)
+					return err
+				}, nil)
+				return
+			}
+			if err != nil {
+				core.ErrorDialog(button, err, title)
+				return
+			}
+			reload()
+			if move {
+				core.MessageSnackbar(app, "moved "+summary.String())
+			} else {
+				core.MessageSnackbar(app, "copied "+summary.String())
+			}
+		})
+	})
+	d.RunDialog(button)
+}
+
+func exportItemsDialog(nodes []TreeNode, button *core.Button) {
+	if len(nodes) == 0 {
+		core.MessageSnackbar(button, "nothing selected")
+		return
+	}
+	d := core.NewBody("Export Selected")
+	itemList(d, nodes)
+	core.NewText(d).SetText("File")
+	file := core.NewTextField(d).SetText("export.json")
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			data, summary, err := ExportItems(nodes)
+			if err != nil {
+				core.ErrorDialog(button, err, "Export Selected")
+				return
+			}
+			if err := os.WriteFile(file.Text(), data, 0o600); err != nil {
+				core.ErrorDialog(button, err, "Export Selected")
+				return
+			}
+			core.MessageSnackbar(app, "exported "+summary.String())
+		})
+	})
+	d.RunDialog(button)
+}
+
+func selectMatchingDialog(button *core.Button) {
+	d := core.NewBody("Select Matching")
+	core.NewText(d).SetText("Path contains")
+	text := core.NewTextField(d)
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar)
+		d.AddOK(bar).OnClick(func(e events.Event) {
+			count := selectMatching(text.Text())
+			core.MessageSnackbar(app, strconv.Itoa(count)+" items selected")
+		})
+	})
+	d.RunDialog(button)
+}
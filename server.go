@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	maxValueSize    = 32 << 20
+	readTimeout     = 10 * time.Second
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+var (
+	errReadOnly    = errors.New("server is read only")
+	errNotBucket   = errors.New("not a bucket")
+	errNotKey      = errors.New("not a key")
+	errCrossSite   = errors.New("cross-site request refused")
+	errContentType = errors.New("content type must be application/json")
+	errPlainAuth   = errors.New("-users needs -tls-cert and -tls-key unless serving on localhost")
+)
+
+// names and paths in responses and request bodies are path escaped, as in
+// /buckets urls, so binary names survive json and "/" within a name is %2F
+type apiItem struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Bucket bool   `json:"bucket"`
+	Size   int    `json:"size,omitempty"`
+}
+
+type apiBucket struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Sequence uint64    `json:"sequence"`
+	Buckets  []apiItem `json:"buckets"`
+	Keys     int       `json:"keys"`
+}
+
+type apiPage struct {
+	Items []apiItem `json:"items"`
+	Next  string    `json:"next,omitempty"`
+}
+
+type apiTransfer struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type apiRename struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+type apiBucketPath struct {
+	Path string `json:"path"`
+}
+
+type apiSequence struct {
+	Path     string  `json:"path"`
+	Sequence *uint64 `json:"sequence"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// server exposes the database operations over http; the database is the
+// global db, as in the editor. Without users there is no authentication.
+type server struct {
+	readOnly bool
+	users    []*apiUser
+	// the editor's globals are not safe for concurrent use, so changes run
+	// one at a time and never alongside a read
+	mu sync.RWMutex
+}
+
+func newServer(s *server) http.Handler {
+	return s.authenticate(s.routes())
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI) //nolint:gosec // error is unimportant
+	})
+	mux.HandleFunc("GET /buckets", s.read(s.listBuckets))
+	mux.HandleFunc("GET /buckets/{path...}", s.read(s.get))
+	mux.HandleFunc("PUT /buckets/{path...}", s.write(s.put))
+	mux.HandleFunc("DELETE /buckets/{path...}", s.write(s.delete))
+	mux.HandleFunc("POST /move", s.write(s.transfer(true)))
+	mux.HandleFunc("POST /copy", s.write(s.transfer(false)))
+	mux.HandleFunc("POST /rename", s.write(s.rename))
+	mux.HandleFunc("POST /empty", s.write(s.empty))
+	mux.HandleFunc("POST /sequence", s.write(s.sequence))
+	return mux
+}
+
+func serveCommand(args []string) error {
+	return listen("serve", args, newServer)
+}
+
+// listen opens the database named in args and serves handler until the
+// process is stopped.
+func listen(name string, args []string, handler func(s *server) http.Handler) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	readOnly := flags.Bool("read-only", false, "open the database read only and refuse changes")
+	usersFile := flags.String("users", "", "json file of users allowed to connect")
+	certFile := flags.String("tls-cert", "", "certificate file, to serve https")
+	keyFile := flags.String("tls-key", "", "private key file of the certificate")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 1 || (*certFile == "") != (*keyFile == "") {
+		return errUsage
+	}
+	s := &server{readOnly: *readOnly}
+	if *usersFile != "" {
+		// passwords and tokens must not cross the network in the clear
+		if *certFile == "" && !isLoopback(*addr) {
+			return fmt.Errorf("%w: %s", errPlainAuth, *addr)
+		}
+		users, err := loadUsers(*usersFile)
+		if err != nil {
+			return err
+		}
+		s.users = users
+	} else if !isLoopback(*addr) {
+		log.Println("warning: serving on", *addr, "without authentication, see -users")
+	}
+	options := &bbolt.Options{Timeout: time.Second, ReadOnly: *readOnly}
+	if err := openDBWith(flags.Arg(0), options); err != nil {
+		return err
+	}
+	defer closeDB()
+	log.Println("serving", flags.Arg(0), "on", *addr)
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler(s),
+		ReadHeaderTimeout: readTimeout,
+	}
+	if *certFile != "" {
+		return srv.ListenAndServeTLS(*certFile, *keyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *server) read(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		h(w, r)
+	}
+}
+
+func (s *server) write(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly {
+			writeError(w, errReadOnly)
+			return
+		}
+		if err := checkSameSite(r); err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// checkSameSite refuses changes a browser makes on behalf of another site:
+// forms cannot send a json content type without a preflight, which is never
+// answered, and browsers name the site a request comes from. Raw key values
+// may also be sent as application/octet-stream.
+func checkSameSite(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/octet-stream" {
+		return errContentType
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return fmt.Errorf("%w: %s", errCrossSite, site)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return fmt.Errorf("%w: origin %s", errCrossSite, origin)
+		}
+	}
+	return nil
+}
+
+// resource is a parsed /buckets url: the bucket path, followed by "keys" for
+// the key list or "keys/{key}" for one key. Names are path escaped, so they
+// may contain "/".
+type resource struct {
+	bucket Path
+	key    []byte
+	isKey  bool
+	isList bool
+}
+
+func parseResource(r *http.Request) (resource, error) {
+	res := resource{}
+	path, err := unescapePath(strings.TrimPrefix(r.URL.EscapedPath(), "/buckets/"))
+	if err != nil {
+		return res, err
+	}
+	switch {
+	case len(path) > 2 && string(path[len(path)-2]) == "keys": //nolint:mnd //bucket, keys, key
+		res.isKey = true
+		res.key = path[len(path)-1]
+		path = path[:len(path)-2]
+	case len(path) > 1 && string(path[len(path)-1]) == "keys":
+		res.isList = true
+		path = path[:len(path)-1]
+	}
+	res.bucket = path
+	return res, nil
+}
+
+func (res resource) path() Path {
+	if res.isKey {
+		return childPath(res.bucket, res.key)
+	}
+	return res.bucket
+}
+
+func (s *server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	items := []apiItem{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if requestUser(r).visible(Path{name}) {
+				items = append(items, newAPIItem(Path{name}, true))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *server) get(w http.ResponseWriter, r *http.Request) {
+	res, err := parseResource(r)
+	switch {
+	case err != nil:
+	case res.isKey:
+		err = authorize(r, false, res.path())
+	case !requestUser(r).visible(res.bucket):
+		// buckets leading to a prefix are listed by name only
+		err = fmt.Errorf("%w: %s", errForbidden, displayPath(res.bucket))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case res.isKey:
+		node, err := currentNode(childPath(res.bucket, res.key))
+		if err == nil && node.IsBucket {
+			err = errNotKey
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if json.Valid(node.Value) {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Write(node.Value) //nolint:gosec // error is unimportant
+	case res.isList:
+		s.listKeys(w, r, res.bucket)
+	default:
+		bucket, err := bucketInfo(res.bucket, requestUser(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bucket)
+	}
+}
+
+// listKeys returns a page of the bucket's keys and nested buckets; next is
+// passed as after to get the following page.
+func (s *server) listKeys(w http.ResponseWriter, r *http.Request, path Path) {
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+			return
+		}
+		limit = n
+	}
+	dir := browseFrom
+	after, err := url.PathUnescape(query.Get("after"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "after must be path escaped"})
+		return
+	}
+	if query.Has("after") {
+		dir = browseAfter
+	}
+	prefix, err := url.PathUnescape(query.Get("prefix"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "prefix must be path escaped"})
+		return
+	}
+	// one extra row tells whether there is another page
+	rows, err := BrowseKeys(path, []byte(prefix), []byte(after), dir, limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page := apiPage{Items: []apiItem{}}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		page.Next = url.PathEscape(string(rows[limit-1].key))
+	}
+	for _, row := range rows {
+		if !requestUser(r).visible(childPath(path, row.key)) {
+			continue
+		}
+		item := newAPIItem(childPath(path, row.key), row.bucket)
+		item.Size = row.Size
+		page.Items = append(page.Items, item)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func bucketInfo(path Path, user *apiUser) (apiBucket, error) {
+	info := apiBucket{Buckets: []apiItem{}}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		info.Name = url.PathEscape(string(path[len(path)-1]))
+		info.Path = escapePath(path)
+		info.Sequence = bucket.Sequence()
+		return bucket.ForEach(func(k, v []byte) error {
+			switch {
+			case !user.visible(childPath(path, k)):
+			case v == nil:
+				info.Buckets = append(info.Buckets, newAPIItem(childPath(path, k), true))
+			default:
+				info.Keys++
+			}
+			return nil
+		})
+	})
+	return info, err
+}
+
+func newAPIItem(path Path, bucket bool) apiItem {
+	return apiItem{Name: url.PathEscape(string(path[len(path)-1])), Path: escapePath(path), Bucket: bucket}
+}
+
+func childPath(parent Path, name []byte) Path {
+	return append(slices.Clone(parent), bytes.Clone(name))
+}
+
+// escapePath is the path as used in /buckets urls.
+func escapePath(path Path) string {
+	parts := []string{}
+	for _, part := range path {
+		parts = append(parts, url.PathEscape(string(part)))
+	}
+	return strings.Join(parts, "/")
+}
+
+// unescapePath reads a path written by escapePath.
+func unescapePath(s string) (Path, error) {
+	path := Path{}
+	for part := range strings.SplitSeq(s, "/") {
+		name, err := url.PathUnescape(part)
+		if err != nil || name == "" {
+			return nil, errInvalidPath
+		}
+		path = append(path, []byte(name))
+	}
+	return path, nil
+}
+
+// put creates a bucket, or creates or replaces a key with the request body.
+func (s *server) put(w http.ResponseWriter, r *http.Request) {
+	res, err := parseResource(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if res.isList {
+		writeError(w, errInvalidPath)
+		return
+	}
+	path := res.path()
+	if !res.isKey {
+		if err := audited(r, "create bucket", path, path, func() error {
+			if err := authorize(r, true, path); err != nil {
+				return err
+			}
+			_, err := CreateBucket(path)
+			return err
+		}); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, newAPIItem(path, true))
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if err := audited(r, "put", path, path, func() error {
+		if err := authorize(r, true, path); err != nil {
+			return err
+		}
+		node, err := currentNode(path)
+		switch {
+		case errors.Is(err, errInvalidPath):
+			status = http.StatusCreated
+			return CreateKey(res.key, value, res.bucket)
+		case err != nil:
+			return err
+		case node.IsBucket:
+			return errNotKey
+		}
+		return UpdateKey(node, value)
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, newAPIItem(path, false))
+}
+
+func (s *server) delete(w http.ResponseWriter, r *http.Request) {
+	res, err := parseResource(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if res.isList {
+		writeError(w, errInvalidPath)
+		return
+	}
+	path := res.path()
+	if err := audited(r, "delete", path, path, func() error {
+		if err := authorize(r, true, path); err != nil {
+			return err
+		}
+		node, err := currentNode(path)
+		switch {
+		case err != nil:
+			return err
+		case res.isKey && node.IsBucket:
+			return errNotKey
+		case !res.isKey && !node.IsBucket:
+			return errNotBucket
+		}
+		return DeleteItem(&node)
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) transfer(move bool) http.HandlerFunc {
+	op := "copy"
+	if move {
+		op = "move"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := apiTransfer{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "from and to are required"})
+			return
+		}
+		from, err := unescapePath(request.From)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "from must be an escaped path"})
+			return
+		}
+		to, err := unescapePath(request.To)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "to must be an escaped path"})
+			return
+		}
+		bucket := false
+		if err := audited(r, op, from, to, func() error {
+			// copying only reads the source, but all of it
+			if err := authorize(r, move, from); err != nil {
+				return err
+			}
+			if err := authorize(r, true, to); err != nil {
+				return err
+			}
+			node, err := currentNode(from)
+			if err != nil {
+				return err
+			}
+			bucket = node.IsBucket
+			return recordChange(op, from, to, func(tx *bbolt.Tx) error {
+				return transferItem(node, to, move, tx)
+			})
+		}); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIItem(to, bucket))
+	}
+}
+
+func (s *server) rename(w http.ResponseWriter, r *http.Request) {
+	request := apiRename{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "path and name are required"})
+		return
+	}
+	path, err := unescapePath(request.Path)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "path must be an escaped path"})
+		return
+	}
+	name, err := url.PathUnescape(request.Name)
+	if err != nil || name == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "name must be an escaped name"})
+		return
+	}
+	to := childPath(parentPath(path), []byte(name))
+	bucket := false
+	if err := audited(r, "rename", path, to, func() error {
+		if err := authorize(r, true, path, to); err != nil {
+			return err
+		}
+		node, err := currentNode(path)
+		if err != nil {
+			return err
+		}
+		bucket = node.IsBucket
+		return RenameItem(node, []byte(name))
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIItem(to, bucket))
+}
+
+// changeBucket runs a change to the bucket at the escaped path.
+func (s *server) changeBucket(w http.ResponseWriter, r *http.Request, op, escaped string, change func(TreeNode) error) {
+	path, err := unescapePath(escaped)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "path must be an escaped path"})
+		return
+	}
+	if err := audited(r, op, path, path, func() error {
+		if err := authorize(r, true, path); err != nil {
+			return err
+		}
+		node, err := currentNode(path)
+		if err != nil {
+			return err
+		}
+		if !node.IsBucket {
+			return errNotBucket
+		}
+		return change(node)
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) empty(w http.ResponseWriter, r *http.Request) {
+	request := apiBucketPath{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "path is required"})
+		return
+	}
+	s.changeBucket(w, r, "empty", request.Path, EmptyBucket)
+}
+
+func (s *server) sequence(w http.ResponseWriter, r *http.Request) {
+	request := apiSequence{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Sequence == nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "path and sequence are required"})
+		return
+	}
+	s.changeBucket(w, r, "set sequence", request.Path, func(node TreeNode) error {
+		return SetSequence(node, *request.Sequence)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("write response", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, errReadOnly), errors.Is(err, errForbidden), errors.Is(err, bbolt.ErrDatabaseReadOnly),
+		errors.Is(err, errCrossSite):
+		status = http.StatusForbidden
+	case errors.Is(err, errContentType):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, errInvalidPath):
+		status = http.StatusNotFound
+	case errors.Is(err, errConflict), errors.Is(err, errKeyExists), errors.Is(err, errBucketExists),
+		errors.Is(err, bbolt.ErrBucketExists), errors.Is(err, bbolt.ErrIncompatibleValue):
+		status = http.StatusConflict
+	case errors.Is(err, errSchemaValue), errors.Is(err, errNotJSON):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, errNotKey), errors.Is(err, errNotBucket), errors.Is(err, errInsideSource),
+		errors.Is(err, errKeyAtRoot), errors.Is(err, bbolt.ErrBucketNameRequired), errors.Is(err, bbolt.ErrKeyRequired):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
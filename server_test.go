@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func request(t *testing.T, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if method != http.MethodGet {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func TestServerCRUD(t *testing.T) {
+	openTestDB(t)
+	h := newServer(&server{})
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/b", ""), http.StatusCreated)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/keys/k%2F1", `{"x":1}`), http.StatusCreated)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/keys/k%2F1", `{"x":2}`), http.StatusOK)
+	w := request(t, h, http.MethodGet, "/buckets/a/keys/k%2F1", "")
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != `{"x":2}` {
+		t.Errorf("value %s", w.Body.String())
+	}
+	w = request(t, h, http.MethodGet, "/buckets/a", "")
+	expectStatus(t, w, http.StatusOK)
+	bucket := apiBucket{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bucket); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.Keys != 1 || len(bucket.Buckets) != 1 || bucket.Buckets[0].Path != "a/b" {
+		t.Errorf("bucket %+v", bucket)
+	}
+	expectStatus(t, request(t, h, http.MethodPost, "/move", `{"from":"a/k%2F1","to":"a/b/k"}`), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a/keys/k%2F1", ""), http.StatusNotFound)
+	expectStatus(t, request(t, h, http.MethodPost, "/copy", `{"from":"a/b","to":"c"}`), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodPost, "/copy", `{"from":"a/b","to":"c"}`), http.StatusConflict)
+	expectStatus(t, request(t, h, http.MethodPost, "/rename", `{"path":"c","name":"d%2Fe"}`), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/d%2Fe/keys/k", ""), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodPost, "/sequence", `{"path":"d%2Fe","sequence":7}`), http.StatusNoContent)
+	expectStatus(t, request(t, h, http.MethodPost, "/empty", `{"path":"d%2Fe"}`), http.StatusNoContent)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/d%2Fe/keys/k", ""), http.StatusNotFound)
+	expectStatus(t, request(t, h, http.MethodDelete, "/buckets/a/b/keys/k", ""), http.StatusNoContent)
+	expectStatus(t, request(t, h, http.MethodDelete, "/buckets/a", ""), http.StatusNoContent)
+	expectStatus(t, request(t, h, http.MethodDelete, "/buckets/a", ""), http.StatusNotFound)
+	expectStatus(t, request(t, h, http.MethodPost, "/move", `{"from":"d%zz","to":"x"}`), http.StatusBadRequest)
+	entries, _, err := readAudit(auditFile(), auditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := map[string]int{}
+	for _, entry := range entries {
+		if entry.Error != "" {
+			ops["failed "+entry.Op]++
+		} else {
+			ops[entry.Op]++
+		}
+	}
+	if ops["move"] != 1 || ops["copy"] != 1 || ops["failed copy"] != 1 || ops["failed delete"] != 1 {
+		t.Errorf("audit log ops %v", ops)
+	}
+}
+
+func TestServerCrossSite(t *testing.T) {
+	openTestDB(t)
+	h := newServer(&server{})
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a", "", "Content-Type", "text/plain"), http.StatusUnsupportedMediaType)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a", "", "Content-Type", ""), http.StatusUnsupportedMediaType)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a", "", "Origin", "https://evil.example"), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a", "", "Sec-Fetch-Site", "cross-site"), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a", "", "Origin", "http://example.com", "Sec-Fetch-Site", "same-origin"), http.StatusCreated)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/keys/k", "\xff", "Content-Type", "application/octet-stream"), http.StatusCreated)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a", "", "Sec-Fetch-Site", "cross-site"), http.StatusOK)
+}
+
+func TestServeNeedsTLS(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	if err := serveCommand([]string{"-addr", "0.0.0.0:0", "-users", "users.json", file}); !errors.Is(err, errPlainAuth) {
+		t.Errorf("users without tls: %v", err)
+	}
+	if err := serveCommand([]string{"-tls-cert", "cert.pem", file}); !errors.Is(err, errUsage) {
+		t.Errorf("certificate without key: %v", err)
+	}
+}
+
+func TestServerPagination(t *testing.T) {
+	openTestDB(t)
+	h := newServer(&server{})
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/ids", ""), http.StatusCreated)
+	keys := [][]byte{}
+	for i := range 5 {
+		keys = append(keys, binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+	keys = append(keys, []byte{0xff, 0xfe})
+	for _, key := range keys {
+		target := "/buckets/ids/keys/" + url.PathEscape(string(key))
+		expectStatus(t, request(t, h, http.MethodPut, target, "v"), http.StatusCreated)
+	}
+	got := [][]byte{}
+	query := "?limit=2"
+	for range len(keys) {
+		w := request(t, h, http.MethodGet, "/buckets/ids/keys"+query, "")
+		expectStatus(t, w, http.StatusOK)
+		page := apiPage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			name, err := url.PathUnescape(item.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, []byte(name))
+		}
+		if page.Next == "" {
+			break
+		}
+		query = "?limit=2&after=" + url.QueryEscape(page.Next)
+	}
+	if !slices.EqualFunc(got, keys, func(a, b []byte) bool { return string(a) == string(b) }) {
+		t.Errorf("keys %x, want %x", got, keys)
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	openTestDB(t)
+	if _, err := CreateBucket(Path{[]byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	h := newServer(&server{readOnly: true})
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a", ""), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/b", ""), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodDelete, "/buckets/a", ""), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodPost, "/empty", `{"path":"a"}`), http.StatusForbidden)
+}
+
+func TestServerPermissions(t *testing.T) {
+	openTestDB(t)
+	for _, path := range []string{"a/b", "a/secret", "c"} {
+		if _, err := CreateBucket(stringToPath(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte("token"))
+	password, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := json.Marshal([]map[string]any{
+		{"name": "scoped", "token_sha256": hex.EncodeToString(sum[:]), "write": true, "prefixes": []string{"a/b", "c"}},
+		{"name": "reader", "password_bcrypt": string(password)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(file, users, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{}
+	if s.users, err = loadUsers(file); err != nil {
+		t.Fatal(err)
+	}
+	h := newServer(s)
+	auth := []string{"Authorization", "Bearer token"}
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a", ""), http.StatusUnauthorized)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a", "", "Authorization", "Bearer wrong"), http.StatusUnauthorized)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/b/keys/k", "v", auth...), http.StatusCreated)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/secret/keys/k", "v", auth...), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a/secret/keys", "", auth...), http.StatusForbidden)
+	w := request(t, h, http.MethodGet, "/buckets/a", "", auth...)
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("bucket outside the prefixes listed: %s", w.Body.String())
+	}
+	// a bucket leading to a prefix may be listed, but not copied
+	expectStatus(t, request(t, h, http.MethodPost, "/copy", `{"from":"a","to":"c/x"}`, auth...), http.StatusForbidden)
+	expectStatus(t, request(t, h, http.MethodPost, "/copy", `{"from":"a/b","to":"c/x"}`, auth...), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/c/x/keys/k", "", auth...), http.StatusOK)
+
+	reader := httptest.NewRequest(http.MethodGet, "/", nil)
+	reader.SetBasicAuth("reader", "secret")
+	basic := []string{"Authorization", reader.Header.Get("Authorization")}
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a/secret/keys", "", basic...), http.StatusOK)
+	expectStatus(t, request(t, h, http.MethodPut, "/buckets/a/keys/k", "v", basic...), http.StatusForbidden)
+	reader.SetBasicAuth("reader", "wrong")
+	wrong := []string{"Authorization", reader.Header.Get("Authorization")}
+	expectStatus(t, request(t, h, http.MethodGet, "/buckets/a", "", wrong...), http.StatusUnauthorized)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"github.com/fsnotify/fsnotify"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.etcd.io/bbolt"
+)
+
+// session holds the state of one open database. The package globals always
+// belong to the current session; the others are kept here until their tab
+// is selected.
+type session struct {
+	label    string
+	db       *bbolt.DB
+	file     string
+	nodes    map[string]TreeNode
+	tree     *core.Tree
+	items    map[string]*core.Tree
+	panes    *core.Splits
+	selected TreeNode
+	formats  map[string]keyFormat
+	schemas  map[string]json.RawMessage
+	compiled map[string]*jsonschema.Schema
+	changed  map[string]bool
+	watcher  *fsnotify.Watcher
+	watched  os.FileInfo
+}
+
+var (
+	tabs     *core.Tabs
+	sessions []*session
+	current  *session
+
+	errNotOpen = errors.New("database is not open")
+)
+
+func (s *session) save() {
+	s.db = db
+	s.file = dbFile
+	s.nodes = nodeMap
+	s.tree = dbTree
+	s.items = treeItems
+	s.panes = panes
+	s.selected = selectedNode
+	s.formats = bucketFormats
+	s.schemas = schemas
+	s.compiled = compiled
+	s.changed = changedItems
+	s.watcher = watcher
+	s.watched = getWatched()
+}
+
+func (s *session) restore() {
+	db = s.db
+	dbFile = s.file
+	nodeMap = s.nodes
+	dbTree = s.tree
+	treeItems = s.items
+	panes = s.panes
+	selectedNode = s.selected
+	bucketFormats = s.formats
+	schemas = s.schemas
+	compiled = s.compiled
+	changedItems = s.changed
+	watcher = s.watcher
+	setWatched(s.watched)
+}
+
+// fileName is the database file of s; the fields of the current session are
+// only saved when another tab is selected.
+func (s *session) fileName() string {
+	if s == current {
+		return dbFile
+	}
+	return s.file
+}
+
+// withSession runs fn with the globals of s, so windows that stay open act
+// on the database they were opened for after another tab is selected.
+func withSession(s *session, fn func() error) error {
+	if !slices.Contains(sessions, s) {
+		return errNotOpen
+	}
+	if s != current {
+		if current != nil {
+			current.save()
+		}
+		s.restore()
+		defer func() {
+			s.save()
+			if current != nil {
+				current.restore()
+			} else {
+				clearSession()
+			}
+		}()
+	}
+	if db == nil {
+		return errNotOpen
+	}
+	return fn()
+}
+
+// clearSession resets the globals for a new session without closing the
+// database of the current one.
+func clearSession() {
+	db = nil
+	dbFile = ""
+	nodeMap = make(map[string]TreeNode)
+	dbTree = nil
+	treeItems = make(map[string]*core.Tree)
+	panes = nil
+	selectedNode = TreeNode{}
+	bucketFormats = make(map[string]keyFormat)
+	schemas = make(map[string]json.RawMessage)
+	compiled = make(map[string]*jsonschema.Schema)
+	changedItems = make(map[string]bool)
+	watcher = nil
+	setWatched(nil)
+}
+
+func activate(s *session) {
+	if s == current {
+		return
+	}
+	if current != nil {
+		current.save()
+	}
+	s.restore()
+	current = s
+	setToolbar(true)
+	keyButton.SetEnabled(len(selectedNode.Path) > 0 && !selectedNode.IsBucket)
+	// pick up changes made while the tab was in the background
+	if db != nil {
+		refreshTree()
+		return
+	}
+	if lockAvailable(dbFile) && openDB(dbFile) == nil {
+		info, _ := os.Stat(dbFile)
+		setWatched(info)
+		reload()
+	}
+}
+
+func setToolbar(enabled bool) {
+	bucketButton.SetEnabled(enabled)
+	keyButton.SetEnabled(enabled)
+	selectionButton.SetEnabled(enabled)
+	auditButton.SetEnabled(enabled)
+	scriptButton.SetEnabled(enabled)
+	app.Update()
+}
+
+// newSession opens file in a new tab; a database locked by another
+// application still gets a tab, which is filled in once the lock is released.
+func newSession(file string) error {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	if current != nil {
+		current.save()
+	}
+	for i, s := range sessions {
+		if s.file == file {
+			tabs.SelectTabIndex(i)
+			activate(s)
+			return nil
+		}
+	}
+	clearSession()
+	err := openDB(file)
+	if err != nil && !isLocked(err) {
+		if current != nil {
+			current.restore()
+		}
+		return err
+	}
+	dbFile = file
+	s := &session{label: tabLabel(file)}
+	frame, _ := tabs.NewTab(s.label)
+	// tabs send a show event to their frame whenever they are selected
+	frame.OnShow(func(e events.Event) {
+		activate(s)
+	})
+	panes = core.NewSplits(frame).SetSplits(.3, .7) //nolint:mnd //percentages
+	left := core.NewFrame(panes)
+	core.NewFrame(panes)
+	restoreFormats()
+	newDBTree(left, filepath.Base(file), getNodes())
+	watchFile(file)
+	sessions = append(sessions, s)
+	current = s
+	tabs.SelectTabIndex(len(sessions) - 1)
+	setToolbar(true)
+	keyButton.SetEnabled(false)
+	restoreState()
+	addRecent(file)
+	saveAllState()
+	return err
+}
+
+// tabLabel is the file name, numbered if another tab has the same name, as
+// tabs are looked up by label.
+func tabLabel(file string) string {
+	base := filepath.Base(file)
+	label := base
+	for i := 2; slices.ContainsFunc(sessions, func(s *session) bool { return s.label == label }); i++ {
+		label = base + " (" + strconv.Itoa(i) + ")"
+	}
+	return label
+}
+
+func closeSession(idx int) {
+	if idx < 0 || idx >= len(sessions) {
+		return
+	}
+	s := sessions[idx]
+	saveAllState()
+	if s == current {
+		s.save()
+		current = nil
+		clearSession()
+	}
+	if s.watcher != nil {
+		s.watcher.Close() //nolint:gosec // error is unimportant
+	}
+	if s.db != nil {
+		stopScripts(s.db)
+		s.db.Close() //nolint:gosec // error is unimportant
+	}
+	sessions = slices.Delete(sessions, idx, idx+1)
+	if len(sessions) == 0 {
+		setToolbar(false)
+		return
+	}
+	if _, i := tabs.CurrentTab(); i >= 0 && i < len(sessions) {
+		activate(sessions[i])
+	}
+}
+
+// otherSessions are the open databases other than the current one.
+func otherSessions() []*session {
+	others := []*session{}
+	for _, s := range sessions {
+		if s != current && s.db != nil {
+			others = append(others, s)
+		}
+	}
+	return others
+}
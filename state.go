@@ -0,0 +1,217 @@
+package main
+
+import (
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+)
+
+// editorSettings are stored in the user config directory and shown in the
+// settings window.
+type editorSettings struct {
+	core.SettingsBase
+
+	// MaxRecent is the number of recently opened files to remember.
+	MaxRecent int `default:"10" min:"1"`
+
+	// Recent are the recently opened files, most recent first.
+	Recent []string
+
+	// LastDir is where the File dialog starts.
+	LastDir string `display:"-"`
+
+	// Databases is the saved UI state of each database, by file.
+	Databases map[string]*databaseState `display:"-"`
+}
+
+type databaseState struct {
+	Bookmarks []Path
+	Open      []Path
+	Selected  Path
+	Formats   map[string]keyFormat
+}
+
+var appSettings = &editorSettings{
+	SettingsBase: core.SettingsBase{Name: "Bbolt Editor"},
+}
+
+func initSettings() {
+	core.TheApp.SetName("BboltEditor")
+	appSettings.File = filepath.Join(core.TheApp.AppDataDir(), "settings.json")
+	core.AddAppSettings(appSettings)
+}
+
+func saveSettings() {
+	if err := core.SaveSettings(appSettings); err != nil {
+		log.Println("save settings", err)
+	}
+}
+
+func addRecent(file string) {
+	recent := slices.DeleteFunc(appSettings.Recent, func(f string) bool {
+		return f == file
+	})
+	recent = slices.Insert(recent, 0, file)
+	if len(recent) > appSettings.MaxRecent {
+		recent = recent[:appSettings.MaxRecent]
+	}
+	appSettings.Recent = recent
+	appSettings.LastDir = filepath.Dir(file)
+	saveSettings()
+}
+
+func recentContext(m *core.Scene, pos image.Point) {
+	if len(appSettings.Recent) == 0 {
+		core.NewText(m).SetText("no recent files")
+		return
+	}
+	for _, file := range appSettings.Recent {
+		button := core.NewButton(m).SetText(file)
+		button.OnClick(func(e events.Event) {
+			if err := newSession(file); err != nil {
+				if isLocked(err) {
+					core.MessageDialog(button, databaseInUse, "Open Database")
+				} else {
+					core.ErrorDialog(button, err, "Open File")
+				}
+			}
+		})
+	}
+}
+
+func fileDialogDir() string {
+	if info, err := os.Stat(appSettings.LastDir); err == nil && info.IsDir() {
+		return appSettings.LastDir
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
+
+func stateFor(file string) *databaseState {
+	if appSettings.Databases == nil {
+		appSettings.Databases = make(map[string]*databaseState)
+	}
+	state, ok := appSettings.Databases[file]
+	if !ok {
+		state = &databaseState{}
+		appSettings.Databases[file] = state
+	}
+	return state
+}
+
+// captureState records the open buckets, selection and key formats of a
+// session; the current session is read from the globals.
+func captureState(s *session) {
+	if s == current {
+		s.save()
+	}
+	if s.file == "" || s.db == nil {
+		return
+	}
+	state := stateFor(s.file)
+	state.Open = nil
+	for name, item := range s.items {
+		if !item.Closed {
+			state.Open = append(state.Open, s.nodes[name].Path)
+		}
+	}
+	state.Selected = s.selected.Path
+	state.Formats = s.formats
+}
+
+// saveAllState saves the state of every open database, when one is opened
+// or closed, a key format changes, or the editor quits.
+func saveAllState() {
+	for _, s := range sessions {
+		captureState(s)
+	}
+	saveSettings()
+}
+
+// key formats are restored before the tree is built as they change its labels
+func restoreFormats() {
+	state := stateFor(dbFile)
+	for bucket, format := range state.Formats {
+		bucketFormats[bucket] = format
+	}
+}
+
+// restoreState opens the buckets and selects the item that were saved for
+// the current database.
+func restoreState() {
+	state := stateFor(dbFile)
+	for _, path := range state.Open {
+		if item, ok := treeItems[pathToString(path)]; ok {
+			item.SetClosed(false)
+		}
+	}
+	name := pathToString(state.Selected)
+	if item, ok := treeItems[name]; ok {
+		revealItem(name)
+		item.Select()
+		updateDetails(name)
+	}
+}
+
+// revealItem opens the buckets containing the item.
+func revealItem(name string) {
+	path := nodeMap[name].Path
+	for i := 1; i < len(path); i++ {
+		if parent, ok := treeItems[pathToString(path[:i])]; ok {
+			parent.SetClosed(false)
+		}
+	}
+}
+
+func isBookmarked(path Path) bool {
+	return slices.ContainsFunc(stateFor(dbFile).Bookmarks, func(p Path) bool {
+		return pathToString(p) == pathToString(path)
+	})
+}
+
+func toggleBookmark(node TreeNode) {
+	state := stateFor(dbFile)
+	if isBookmarked(node.Path) {
+		state.Bookmarks = slices.DeleteFunc(state.Bookmarks, func(p Path) bool {
+			return pathToString(p) == pathToString(node.Path)
+		})
+	} else {
+		state.Bookmarks = append(state.Bookmarks, node.Path)
+	}
+	saveSettings()
+}
+
+func bookmarkText(node TreeNode) string {
+	if isBookmarked(node.Path) {
+		return "Remove Bookmark"
+	}
+	return "Add Bookmark"
+}
+
+func bookmarksContext(m *core.Scene, pos image.Point) {
+	bookmarks := stateFor(dbFile).Bookmarks
+	if len(bookmarks) == 0 {
+		core.NewText(m).SetText("no bookmarks")
+		return
+	}
+	for _, path := range bookmarks {
+		name := pathToString(path)
+		button := core.NewButton(m).SetText(displayPath(path))
+		button.OnClick(func(e events.Event) {
+			item, ok := treeItems[name]
+			if !ok {
+				core.MessageSnackbar(button, displayPath(path)+" no longer exists")
+				return
+			}
+			revealItem(name)
+			dbTree.UnselectAll()
+			item.Select()
+			updateDetails(name)
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errNotObject    = errors.New("not a json object")
+	errFieldMissing = errors.New("field does not exist")
+)
+
+// transformData is what a transform template is executed with: .Key, the
+// value as text in .Raw and, for json values, the parsed value in .Value.
+type transformData struct {
+	Key   string
+	Raw   string
+	Value *jsonValue
+}
+
+// transformFuncs edit json values in place, keeping field order; the value
+// is the last argument so they can be chained in a pipeline, e.g.
+// {{ .Value | set "status" "active" | del "tmp" | json }}
+var transformFuncs = template.FuncMap{
+	"get":     transformGet,
+	"has":     transformHas,
+	"set":     transformSet,
+	"setJSON": transformSetJSON,
+	"del":     transformDel,
+	"rename":  transformRename,
+	"json":    transformJSON,
+}
+
+// transformItem is a changed or failed key of a transform.
+type transformItem struct {
+	Key   string
+	Old   string
+	New   string
+	Error string
+}
+
+type transformSummary struct {
+	Changed   int
+	Unchanged int
+	Failed    int
+	Backup    string
+	Items     []transformItem
+}
+
+func (s transformSummary) String() string {
+	return fmt.Sprintf("%d changed, %d unchanged, %d failed", s.Changed, s.Unchanged, s.Failed)
+}
+
+func (s *transformSummary) add(item transformItem, limit int) {
+	if item.Error != "" {
+		s.Failed++
+	} else {
+		s.Changed++
+	}
+	if len(s.Items) < limit {
+		s.Items = append(s.Items, item)
+	}
+}
+
+// merge adds the counts and items of a committed chunk.
+func (s *transformSummary) merge(part transformSummary, limit int) {
+	s.Changed += part.Changed
+	s.Unchanged += part.Unchanged
+	s.Failed += part.Failed
+	for _, item := range part.Items {
+		if len(s.Items) < limit {
+			s.Items = append(s.Items, item)
+		}
+	}
+}
+
+func parseTransform(text string) (*template.Template, error) {
+	return template.New("transform").Funcs(transformFuncs).Parse(text)
+}
+
+// transformValue returns the new value of key; it is nil when the template
+// writes nothing or the same json value.
+func transformValue(tmpl *template.Template, key, value []byte) ([]byte, error) {
+	data := transformData{Key: string(key), Raw: string(value)}
+	old, err := parseJSON(value)
+	if err == nil {
+		// the funcs change the value in place
+		data.Value, _ = parseJSON(value)
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	out := bytes.TrimSpace(buf.Bytes())
+	if len(out) == 0 || bytes.Equal(out, value) {
+		return nil, nil
+	}
+	if updated, err := parseJSON(out); err == nil && old != nil && sameJSON(old, updated) {
+		return nil, nil
+	}
+	return bytes.Clone(out), nil
+}
+
+// checkTransform applies the template to a key and checks the result against
+// the bucket schema.
+func checkTransform(tmpl *template.Template, path Path, k, v []byte) ([]byte, transformItem) {
+	item := transformItem{Key: formatFor(path).encode(k), Old: preview(v)}
+	value, err := transformValue(tmpl, k, v)
+	if err == nil && value != nil {
+		err = validateValue(path, value)
+	}
+	if err != nil {
+		item.Error = err.Error()
+		return nil, item
+	}
+	item.New = preview(value)
+	return value, item
+}
+
+// PreviewTransform applies the template to every key of the bucket without
+// changing anything, returning the counts and the first limit changed or
+// failed keys.
+func PreviewTransform(path Path, tmpl *template.Template, limit int) (transformSummary, error) {
+	summary := transformSummary{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket, err := getBucket(path, tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			value, item := checkTransform(tmpl, path, k, v)
+			if value == nil && item.Error == "" {
+				summary.Unchanged++
+				return nil
+			}
+			summary.add(item, limit)
+			return nil
+		})
+	})
+	return summary, err
+}
+
+// TransformBucket applies the template to every key of the bucket, committing
+// every chunk changed keys. Before each commit the old values are appended to
+// the backup file as a change script that undoes the transform when replayed.
+// Keys that fail are left unchanged and reported.
+func TransformBucket(path Path, tmpl *template.Template, chunk int, backup string, limit int) (transformSummary, error) {
+	if chunk < 1 {
+		chunk = copyChunkSize
+	}
+	log.Println("transform", pathToString(path), "backup", backup)
+	summary := transformSummary{}
+	var after []byte
+	for {
+		// the chunk is only counted once it is committed
+		part := transformSummary{}
+		changes := []txChange{}
+		done := false
+		var next []byte
+		var undo bytes.Buffer
+		written := backupSize(backup)
+		err := db.Update(func(tx *bbolt.Tx) error {
+			bucket, err := getBucket(path, tx)
+			if err != nil {
+				return err
+			}
+			type update struct{ key, old, new []byte }
+			updates := []update{}
+			c := bucket.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(updates) < chunk; k, v = c.Next() {
+				next = bytes.Clone(k)
+				if v == nil {
+					continue
+				}
+				value, item := checkTransform(tmpl, path, k, v)
+				switch {
+				case item.Error != "":
+					part.add(item, limit)
+				case value == nil:
+					part.Unchanged++
+				default:
+					updates = append(updates, update{key: bytes.Clone(k), old: bytes.Clone(v), new: value})
+					part.add(item, limit)
+				}
+			}
+			done = k == nil
+			enc := json.NewEncoder(&undo)
+			enc.SetEscapeHTML(false)
+			for _, u := range updates {
+				key := childPath(path, u.key)
+				entry := auditEntry{
+					Time:     time.Now(),
+					User:     osUser(),
+					Database: dbFile,
+					Op:       "update key",
+					Path:     auditPath(key),
+					Detail:   "undo transform",
+					Old:      fullAuditValue(u.new),
+					New:      fullAuditValue(u.old),
+				}
+				if err := enc.Encode(entry); err != nil {
+					return err
+				}
+				if err := bucket.Put(u.key, u.new); err != nil {
+					return err
+				}
+				changes = append(changes, txChange{
+					op:   "update key",
+					from: key,
+					to:   key,
+					old:  newAuditValue(TreeNode{Value: u.old}),
+					new:  newAuditValue(TreeNode{Value: u.new}),
+				})
+			}
+			// the backup is written before the chunk is committed
+			return appendBackup(backup, undo.Bytes())
+		})
+		if err != nil {
+			if undo.Len() > 0 {
+				// the chunk was not committed, so it must not be undone
+				os.Truncate(backup, written) //nolint:errcheck,gosec //already failing
+			}
+			return summary, err
+		}
+		summary.merge(part, limit)
+		after = next
+		if undo.Len() > 0 {
+			summary.Backup = backup
+		}
+		writeChanges(changes, "transform")
+		if done {
+			return summary, nil
+		}
+	}
+}
+
+func appendBackup(file string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func backupSize(file string) int64 {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// transformBackupFile is kept next to the database, like the audit log.
+func transformBackupFile() string {
+	return dbFile + ".undo-" + time.Now().Format("20060102-150405") + ".jsonl"
+}
+
+// fieldPath splits a dotted path; numbers index arrays.
+func fieldPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+func (v *jsonValue) field(name string) (int, *jsonValue) {
+	if v.Kind == jsonArray {
+		i, ok := arrayIndex(name)
+		if !ok || i >= len(v.Children) {
+			return -1, nil
+		}
+		return i, v.Children[i]
+	}
+	for i, child := range v.Children {
+		if child.Key == name {
+			return i, child
+		}
+	}
+	return -1, nil
+}
+
+// arrayIndex parses an array index the way RFC 6901 allows it: digits only,
+// without a sign or leading zeros.
+func arrayIndex(name string) (int, bool) {
+	if name == "" || (len(name) > 1 && name[0] == '0') {
+		return -1, false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return -1, false
+		}
+	}
+	i, err := strconv.Atoi(name)
+	return i, err == nil
+}
+
+// lookup returns the container holding the last field of path; with create,
+// missing objects on the way are added.
+func (v *jsonValue) lookup(path string, create bool) (*jsonValue, string, error) {
+	if v == nil {
+		return nil, "", errNotJSON
+	}
+	names := fieldPath(path)
+	parent := v
+	for _, name := range names[:len(names)-1] {
+		if !parent.isContainer() {
+			return nil, "", fmt.Errorf("%s: %w", path, errNotObject)
+		}
+		_, child := parent.field(name)
+		if child == nil {
+			if !create || parent.Kind != jsonObject {
+				return nil, "", fmt.Errorf("%s: %w", path, errFieldMissing)
+			}
+			child = &jsonValue{Kind: jsonObject, Key: name}
+			parent.Children = append(parent.Children, child)
+		}
+		parent = child
+	}
+	if !parent.isContainer() {
+		return nil, "", fmt.Errorf("%s: %w", path, errNotObject)
+	}
+	return parent, names[len(names)-1], nil
+}
+
+// get returns a field as text: strings, numbers and booleans as they are,
+// objects and arrays as json, and "" for null or missing fields.
+func transformGet(path string, v *jsonValue) (string, error) {
+	parent, name, err := v.lookup(path, false)
+	if errors.Is(err, errFieldMissing) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, field := parent.field(name)
+	switch {
+	case field == nil, field.Kind == jsonNull:
+		return "", nil
+	case field.isContainer():
+		return string(field.marshal()), nil
+	}
+	return field.Scalar, nil
+}
+
+func transformHas(path string, v *jsonValue) bool {
+	parent, name, err := v.lookup(path, false)
+	if err != nil {
+		return false
+	}
+	_, field := parent.field(name)
+	return field != nil
+}
+
+// set sets a field to a template value, e.g. a string, number or bool.
+func transformSet(path string, value any, v *jsonValue) (*jsonValue, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return transformSetJSON(path, string(data), v)
+}
+
+// setJSON sets a field to a json value, e.g. "[]" or "{\"a\": 1}".
+func transformSetJSON(path, value string, v *jsonValue) (*jsonValue, error) {
+	field, err := parseJSON([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	parent, name, err := v.lookup(path, true)
+	if err != nil {
+		return nil, err
+	}
+	i, old := parent.field(name)
+	switch {
+	case old != nil:
+		field.Key = old.Key
+		parent.Children[i] = field
+	case parent.Kind == jsonObject:
+		field.Key = name
+		parent.Children = append(parent.Children, field)
+	default:
+		return nil, fmt.Errorf("%s: %w", path, errIndexOutRange)
+	}
+	return v, nil
+}
+
+// del removes a field; missing fields are ignored.
+func transformDel(path string, v *jsonValue) (*jsonValue, error) {
+	parent, name, err := v.lookup(path, false)
+	if errors.Is(err, errFieldMissing) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i, _ := parent.field(name); i >= 0 {
+		return v, parent.removeChild(i)
+	}
+	return v, nil
+}
+
+// rename renames the last field of path, keeping its position; missing
+// fields are ignored.
+func transformRename(path, name string, v *jsonValue) (*jsonValue, error) {
+	parent, old, err := v.lookup(path, false)
+	if errors.Is(err, errFieldMissing) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parent.Kind != jsonObject {
+		return nil, fmt.Errorf("%s: %w", path, errNotObject)
+	}
+	if i, _ := parent.field(old); i >= 0 {
+		return v, parent.renameChild(i, name)
+	}
+	return v, nil
+}
+
+func transformJSON(v *jsonValue) (string, error) {
+	if v == nil {
+		return "", errNotJSON
+	}
+	return string(v.marshal()), nil
+}
+
+func transformCommand(args []string) error {
+	flags := flag.NewFlagSet("transform", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	chunk := flags.Int("chunk", copyChunkSize, "changed keys per transaction")
+	backup := flags.String("backup", "", "undo script (default dbfile.undo-time.jsonl)")
+	show := flags.Int("show", 10, "changed or failed keys to list") //nolint:mnd //default
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 3 { //nolint:mnd //dbfile, bucket and template
+		return errUsage
+	}
+	tmpl, err := parseTransform(flags.Arg(2))
+	if err != nil {
+		return err
+	}
+	if err := openDB(flags.Arg(0)); err != nil {
+		return err
+	}
+	defer closeDB()
+	path := stringToPath(flags.Arg(1))
+	var summary transformSummary
+	if *dryRun {
+		summary, err = PreviewTransform(path, tmpl, *show)
+	} else {
+		if *backup == "" {
+			*backup = transformBackupFile()
+		}
+		summary, err = TransformBucket(path, tmpl, *chunk, *backup, *show)
+	}
+	for _, item := range summary.Items {
+		if item.Error != "" {
+			fmt.Printf("%s: failed: %s\n", item.Key, item.Error)
+			continue
+		}
+		fmt.Printf("%s: %s -> %s\n", item.Key, item.Old, item.New)
+	}
+	fmt.Println(summary)
+	if summary.Backup != "" {
+		fmt.Println("replay", summary.Backup, "to undo")
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransformUndo(t *testing.T) {
+	openTestDB(t)
+	bucket := Path{[]byte("users"), {0xff, 0x00}}
+	if _, err := CreateBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{
+		"\xff\x01": `{"n":1}`,
+		"a/b":      `{"n":2}`,
+		"plain":    `{"n":3}`,
+	}
+	for key, value := range values {
+		if err := CreateKey([]byte(key), []byte(value), bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl, err := parseTransform(`{{ .Value | set "status" "active" | json }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(t.TempDir(), "undo.jsonl")
+	summary, err := TransformBucket(bucket, tmpl, 2, backup, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Changed != 3 || summary.Failed != 0 || len(summary.Items) != 2 {
+		t.Errorf("summary %v, %d items", summary, len(summary.Items))
+	}
+	for key := range values {
+		node, err := currentNode(childPath(bucket, []byte(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(node.Value), `"status":"active"`) {
+			t.Errorf("%q is %s after the transform", key, node.Value)
+		}
+	}
+
+	f, err := os.Open(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	steps, err := readScript(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Replay(steps, false); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		node, err := currentNode(childPath(bucket, []byte(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(node.Value) != value {
+			t.Errorf("%q is %s after undo, want %s", key, node.Value, value)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strconv"
+
+	"cogentcore.org/core/core"
+	"cogentcore.org/core/events"
+	"cogentcore.org/core/text/textcore"
+)
+
+// number of changed or failed keys listed by the transform dialog
+const transformPreviewSize = 20
+
+const transformExample = `{{ .Value | set "status" "active" | rename "old" "new" | json }}`
+
+func transformDialog(node TreeNode, button *core.Button) {
+	items := []transformItem{}
+	title := "Transform Values"
+	d := core.NewBody(title)
+	core.NewText(d).SetText("Bucket")
+	core.NewText(d).SetText(displayPath(node.Path))
+	core.NewText(d).SetText("Go template run for every key; its output is the new value, nothing or the same json leaves the key unchanged. " +
+		".Key, .Raw and .Value are the key, the value as text and the parsed json value; " +
+		"get, has, set, setJSON, del, rename and json read and edit .Value, fields are dotted paths")
+	te := textcore.NewEditor(d)
+	buf := te.Lines.SetText([]byte(transformExample))
+	status := core.NewText(d)
+	table := core.NewTable(d)
+	table.SetReadOnly(true)
+	table.SetSlice(&items)
+	show := func(summary transformSummary) {
+		items = append(items[:0], summary.Items...)
+		text := summary.String()
+		if summary.Changed+summary.Failed > len(items) {
+			text += ", the first " + strconv.Itoa(len(items)) + " are listed"
+		}
+		if summary.Backup != "" {
+			text += "; replay " + summary.Backup + " to undo"
+		}
+		status.SetText(text).Update()
+		table.Update()
+	}
+	d.AddBottomBar(func(bar *core.Frame) {
+		d.AddCancel(bar).SetText("Close")
+		core.NewButton(bar).SetText("Preview").OnClick(func(e events.Event) {
+			tmpl, err := parseTransform(string(buf.Text()))
+			if err != nil {
+				core.ErrorDialog(d, err, title)
+				return
+			}
+			summary, err := PreviewTransform(node.Path, tmpl, transformPreviewSize)
+			if err != nil {
+				core.ErrorDialog(d, err, title)
+				return
+			}
+			show(summary)
+		})
+		core.NewButton(bar).SetText("Apply").OnClick(func(e events.Event) {
+			tmpl, err := parseTransform(string(buf.Text()))
+			if err != nil {
+				core.ErrorDialog(d, err, title)
+				return
+			}
+			summary, err := TransformBucket(node.Path, tmpl, copyChunkSize, transformBackupFile(), transformPreviewSize)
+			show(summary)
+			if err != nil {
+				core.ErrorDialog(d, err, title)
+			}
+			if summary.Changed > 0 {
+				reload()
+			}
+		})
+	})
+	d.RunWindowDialog(button)
+}
@@ -14,6 +14,8 @@ type TreeNode struct {
 	Name     []byte
 	IsBucket bool
 	Value    []byte
+	Sequence uint64
+	Version  []byte
 	Path     Path
 	Children []*TreeNode
 }
@@ -37,6 +39,13 @@ func pathToString(path Path) string {
 	return strings.Join(array, "/")
 }
 
+func parentPath(path Path) Path {
+	if len(path) == 0 {
+		return Path{}
+	}
+	return path[:len(path)-1]
+}
+
 func stringToPath(s string) Path {
 	path := Path{}
 	array := strings.SplitSeq(s, "/")
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cogentcore.org/core/core"
+	"github.com/fsnotify/fsnotify"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	watchDelay = 500 * time.Millisecond
+	watchRetry = 2 * time.Second
+	watchProbe = 100 * time.Millisecond
+)
+
+var (
+	watcher      *fsnotify.Watcher
+	changedItems = make(map[string]bool)
+	// watchedFile is read by the refresh timer, so it is only used through
+	// getWatched and setWatched
+	watchedFile os.FileInfo
+	watchMu     sync.Mutex
+)
+
+func getWatched() os.FileInfo {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	return watchedFile
+}
+
+func setWatched(info os.FileInfo) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchedFile = info
+}
+
+// watchFile refreshes the tree when another process changes file. The
+// directory is watched so files replaced by a rename are noticed too.
+func watchFile(file string) {
+	if watcher != nil {
+		watcher.Close() //nolint:gosec // error is unimportant
+		watcher = nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("watch", err)
+		return
+	}
+	if err := w.Add(filepath.Dir(file)); err != nil {
+		log.Println("watch", err)
+		w.Close() //nolint:gosec // error is unimportant
+		return
+	}
+	watcher = w
+	info, _ := os.Stat(file)
+	setWatched(info)
+	name := filepath.Clean(file)
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Hour, func() {
+		if !refreshFromDisk(file) {
+			timer.Reset(watchRetry)
+		}
+	})
+	timer.Stop()
+	go func() {
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					timer.Stop()
+					return
+				}
+				if filepath.Clean(event.Name) != name || event.Op == fsnotify.Chmod {
+					continue
+				}
+				// wait for writes to settle
+				timer.Reset(watchDelay)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Println("watch", err)
+			}
+		}
+	}()
+}
+
+// refreshFromDisk runs outside the gui loop; it returns false if the
+// database is locked by another process and should be tried again later.
+func refreshFromDisk(file string) bool {
+	info, err := os.Stat(file)
+	app.AsyncLock()
+	if dbFile != file {
+		app.AsyncUnlock()
+		return true
+	}
+	if err != nil {
+		core.MessageSnackbar(app, "database file was removed")
+		app.AsyncUnlock()
+		return true
+	}
+	watched := getWatched()
+	reopen := db == nil || watched == nil || !os.SameFile(info, watched)
+	app.AsyncUnlock()
+	if reopen && !lockAvailable(file) {
+		return false
+	}
+	app.AsyncLock()
+	defer app.AsyncUnlock()
+	if reopen {
+		log.Println("reopening", file)
+		if err := openDB(file); err != nil {
+			log.Println("reopen", err)
+			return false
+		}
+		setWatched(info)
+	}
+	refreshTree()
+	return true
+}
+
+// lockAvailable tries a short read only open, so the gui is not blocked
+// while another process holds the database.
+func lockAvailable(file string) bool {
+	probe, err := bbolt.Open(file, 0o600, &bbolt.Options{ReadOnly: true, Timeout: watchProbe})
+	if err != nil {
+		return false
+	}
+	probe.Close() //nolint:gosec // error is unimportant
+	return true
+}
+
+// refreshTree rebuilds the tree if the database no longer matches it, keeping
+// open buckets and the selection; the details pane is left alone so unsaved
+// edits are not lost.
+func refreshTree() {
+	old := nodeMap
+	nodes := getNodes()
+	changed, removed := diffNodes(old, nodeMap)
+	if len(changed) == 0 && removed == 0 {
+		return
+	}
+	open := []string{}
+	for name, item := range treeItems {
+		if !item.Closed {
+			open = append(open, name)
+		}
+	}
+	selected := []string{}
+	for _, item := range dbTree.GetSelectedNodes() {
+		selected = append(selected, item.AsCoreTree().Name)
+	}
+	left, ok := dbTree.Parent.(*core.Frame)
+	if !ok {
+		return
+	}
+	changedItems = changed
+	left.DeleteChildren()
+	newDBTree(left, filepath.Base(dbFile), nodes)
+	for _, name := range open {
+		if item, ok := treeItems[name]; ok {
+			item.SetClosed(false)
+		}
+	}
+	for _, name := range selected {
+		if item, ok := treeItems[name]; ok {
+			item.Select()
+		}
+	}
+	left.Update()
+	message := fmt.Sprintf("database changed on disk: %d items added or changed, %d removed", len(changed), removed)
+	shown := pathToString(selectedNode.Path)
+	if _, ok := nodeMap[shown]; changed[shown] || (!ok && shown != "") {
+		message += ", including the item in the details pane"
+	}
+	core.MessageSnackbar(app, message)
+}
+
+func diffNodes(old, current map[string]TreeNode) (map[string]bool, int) {
+	changed := make(map[string]bool)
+	for name, node := range current {
+		before, ok := old[name]
+		if !ok || before.IsBucket != node.IsBucket || !bytes.Equal(before.Value, node.Value) ||
+			!bytes.Equal(before.Version, node.Version) {
+			changed[name] = true
+		}
+	}
+	removed := 0
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			removed++
+		}
+	}
+	return changed, removed
+}
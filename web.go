@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+func webCommand(args []string) error {
+	return listen("web", args, newWebServer)
+}
+
+// newWebServer serves the browser editor, which uses the REST API served
+// alongside it.
+func newWebServer(s *server) http.Handler {
+	api := s.routes()
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(static))
+	for _, pattern := range []string{
+		"/openapi.json", "/buckets", "/buckets/", "/move", "/copy", "/rename", "/empty", "/sequence",
+	} {
+		mux.Handle(pattern, api)
+	}
+	return s.authenticate(mux)
+}
@@ -0,0 +1,474 @@
+"use strict";
+
+// items are identified by their escaped url path, as returned by the api
+const open = new Set();
+let selected = null;
+
+const tree = document.getElementById("tree");
+const details = document.getElementById("details");
+const menu = document.getElementById("menu");
+
+async function api(method, url, body) {
+  const options = { method };
+  if (method !== "GET") {
+    // the server refuses changes without it, so other sites cannot make them
+    options.headers = { "Content-Type": "application/json" };
+  }
+  if (body !== undefined) {
+    options.body = typeof body === "string" ? body : JSON.stringify(body);
+  }
+  const response = await fetch(url, options);
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      message = (await response.json()).error;
+    } catch (e) {
+      // not a json error
+    }
+    throw new Error(message);
+  }
+  return response;
+}
+
+function segments(path) {
+  return path.split("/");
+}
+
+// decode unescapes a name from the api; binary names that are not valid
+// UTF-8 stay escaped
+function decode(part) {
+  try {
+    return decodeURIComponent(part);
+  } catch {
+    return part;
+  }
+}
+
+function encode(name) {
+  try {
+    decodeURIComponent(name);
+  } catch {
+    return name;
+  }
+  return encodeURIComponent(name);
+}
+
+// normalize escapes paths from the api the same way as paths built here
+function normalize(path) {
+  return segments(path)
+    .map((part) => encode(decode(part)))
+    .join("/");
+}
+
+// rawPath is the "/" separated path shown to the user
+function rawPath(path) {
+  return segments(path).map(decode).join("/");
+}
+
+function displayName(path) {
+  const parts = segments(path);
+  return decode(parts[parts.length - 1]);
+}
+
+function bucketURL(path) {
+  return "buckets/" + path;
+}
+
+function keyURL(path) {
+  const parts = segments(path);
+  return "buckets/" + parts.slice(0, -1).join("/") + "/keys/" + parts[parts.length - 1];
+}
+
+function childPath(parent, name) {
+  return (parent ? parent + "/" : "") + encode(name);
+}
+
+function parentOf(path) {
+  return segments(path).slice(0, -1).join("/");
+}
+
+function showMessage(text) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.hidden = false;
+  clearTimeout(showMessage.timer);
+  showMessage.timer = setTimeout(() => (message.hidden = true), 4000);
+}
+
+function element(tag, text, className) {
+  const el = document.createElement(tag);
+  if (text !== undefined) {
+    el.textContent = text;
+  }
+  if (className) {
+    el.className = className;
+  }
+  return el;
+}
+
+function button(parent, text, onclick) {
+  const b = element("button", text);
+  b.addEventListener("click", onclick);
+  parent.appendChild(b);
+  return b;
+}
+
+// tree
+
+async function loadTree() {
+  tree.replaceChildren();
+  try {
+    const buckets = await (await api("GET", "buckets")).json();
+    for (const item of buckets) {
+      tree.appendChild(treeItem(item));
+    }
+  } catch (e) {
+    showMessage(e.message);
+  }
+}
+
+function treeItem(item) {
+  item.path = normalize(item.path);
+  const li = element("li");
+  const row = element("div", undefined, "row");
+  row.dataset.path = item.path;
+  const toggle = element("span", item.bucket ? (open.has(item.path) ? "▾" : "▸") : "", "toggle");
+  row.append(toggle, element("span", (item.bucket ? "🗀 " : "") + decode(item.name)));
+  if (selected && selected.path === item.path) {
+    row.classList.add("selected");
+  }
+  li.appendChild(row);
+  row.addEventListener("click", () => select(item));
+  row.addEventListener("contextmenu", (e) => {
+    e.preventDefault();
+    select(item);
+    showMenu(item, e.clientX, e.clientY);
+  });
+  if (item.bucket) {
+    const children = element("ul");
+    li.appendChild(children);
+    toggle.addEventListener("click", (e) => {
+      e.stopPropagation();
+      if (open.has(item.path)) {
+        open.delete(item.path);
+        toggle.textContent = "▸";
+        children.replaceChildren();
+      } else {
+        open.add(item.path);
+        toggle.textContent = "▾";
+        loadChildren(item.path, children);
+      }
+    });
+    if (open.has(item.path)) {
+      loadChildren(item.path, children);
+    }
+  }
+  return li;
+}
+
+// loadChildren appends a page of the bucket's contents, with a link for the
+// next page.
+async function loadChildren(path, list, after) {
+  let url = bucketURL(path) + "/keys";
+  if (after !== undefined) {
+    url += "?after=" + encodeURIComponent(after);
+  }
+  try {
+    const page = await (await api("GET", url)).json();
+    for (const item of page.items) {
+      list.appendChild(treeItem(item));
+    }
+    if (page.next !== undefined) {
+      const more = element("li", "more…", "row more");
+      more.addEventListener("click", () => {
+        more.remove();
+        loadChildren(path, list, page.next);
+      });
+      list.appendChild(more);
+    }
+  } catch (e) {
+    showMessage(e.message);
+  }
+}
+
+function select(item) {
+  selected = item;
+  for (const row of tree.querySelectorAll(".row.selected")) {
+    row.classList.remove("selected");
+  }
+  const row = tree.querySelector(`.row[data-path="${CSS.escape(item.path)}"]`);
+  if (row) {
+    row.classList.add("selected");
+  }
+  showDetails(item);
+}
+
+// reload rebuilds the tree, keeping open buckets, and selects path if given
+async function reload(path, bucket) {
+  if (path !== undefined) {
+    selected = path ? { path, name: segments(path).pop(), bucket } : null;
+    for (let p = parentOf(path || ""); p; p = parentOf(p)) {
+      open.add(p);
+    }
+  }
+  await loadTree();
+  if (selected) {
+    showDetails(selected);
+  } else {
+    details.replaceChildren(element("p", "select a bucket or key, right click for actions", "hint"));
+  }
+}
+
+// details pane
+
+async function showDetails(item) {
+  details.replaceChildren();
+  if (item.bucket) {
+    await bucketDetails(item);
+  } else {
+    await keyDetails(item);
+  }
+}
+
+async function bucketDetails(item) {
+  let info;
+  try {
+    info = await (await api("GET", bucketURL(item.path))).json();
+  } catch (e) {
+    details.appendChild(element("p", e.message, "problems"));
+    return;
+  }
+  details.append(
+    element("h3", "Bucket:"),
+    element("p", "Path: " + rawPath(item.path)),
+    element("p", "Name: " + decode(info.name)),
+    element("p", `Contains ${info.buckets.length} buckets and ${info.keys} keys`),
+  );
+  const sequence = element("div", undefined, "actions");
+  sequence.appendChild(element("span", "Sequence:"));
+  const input = element("input");
+  input.type = "number";
+  input.min = "0";
+  input.value = info.sequence;
+  sequence.appendChild(input);
+  button(sequence, "Set Sequence", () =>
+    run(() => api("POST", "sequence", { path: item.path, sequence: Number(input.value) }), item.path, true),
+  );
+  details.appendChild(sequence);
+  const actions = element("div", undefined, "actions");
+  for (const action of bucketActions(item)) {
+    button(actions, action.text, action.run);
+  }
+  details.appendChild(actions);
+}
+
+async function keyDetails(item) {
+  let data;
+  try {
+    data = new Uint8Array(await (await api("GET", keyURL(item.path))).arrayBuffer());
+  } catch (e) {
+    details.appendChild(element("p", e.message, "problems"));
+    return;
+  }
+  details.append(element("h3", "Key:"), element("p", "Path: " + rawPath(item.path)), element("p", "Name: " + decode(item.name)));
+  let text;
+  try {
+    text = new TextDecoder("utf-8", { fatal: true }).decode(data);
+  } catch (e) {
+    const hex = Array.from(data.slice(0, 256), (b) => b.toString(16).padStart(2, "0")).join(" ");
+    details.append(element("p", `binary value of ${data.length} bytes, it cannot be edited here`, "hint"), element("pre", hex));
+  }
+  const problems = element("p", "", "problems");
+  if (text !== undefined) {
+    const editor = element("textarea");
+    editor.value = text;
+    details.appendChild(editor);
+    const edit = element("div", undefined, "actions");
+    button(edit, "Validate Json", () => {
+      try {
+        JSON.parse(editor.value);
+        problems.textContent = "";
+        showMessage("valid json");
+      } catch (e) {
+        problems.textContent = e.message;
+      }
+    });
+    button(edit, "Format Json", () => {
+      try {
+        editor.value = JSON.stringify(JSON.parse(editor.value), null, 2);
+        problems.textContent = "";
+      } catch (e) {
+        problems.textContent = e.message;
+      }
+    });
+    button(edit, "Reset", () => {
+      editor.value = text;
+      problems.textContent = "";
+    });
+    button(edit, "Update", async () => {
+      if (editor.value === text) {
+        showMessage("no changes");
+        return;
+      }
+      try {
+        await api("PUT", keyURL(item.path), editor.value);
+        showMessage("updated " + rawPath(item.path));
+        showDetails(item);
+      } catch (e) {
+        problems.textContent = e.message;
+      }
+    });
+    details.appendChild(edit);
+  }
+  details.appendChild(problems);
+  const actions = element("div", undefined, "actions");
+  for (const action of keyActions(item)) {
+    button(actions, action.text, action.run);
+  }
+  details.appendChild(actions);
+}
+
+// actions, shared by the context menu and the details pane
+
+// run performs a change and reloads the tree, selecting path
+async function run(change, path, bucket) {
+  try {
+    await change();
+    await reload(path, bucket);
+  } catch (e) {
+    showMessage(e.message);
+  }
+}
+
+function askPath(title, item) {
+  const to = prompt(title + ' to ("/" separated path)', rawPath(item.path));
+  if (to === null || to === "" || to === rawPath(item.path)) {
+    return null;
+  }
+  return to;
+}
+
+function escapeRaw(path) {
+  return path.split("/").map(encode).join("/");
+}
+
+function transferAction(text, endpoint, item) {
+  return {
+    text,
+    run: () => {
+      const to = askPath(text, item);
+      if (to !== null) {
+        run(() => api("POST", endpoint, { from: item.path, to: escapeRaw(to) }), escapeRaw(to), item.bucket);
+      }
+    },
+  };
+}
+
+function renameAction(text, item) {
+  return {
+    text,
+    run: () => {
+      const old = displayName(item.path);
+      const name = prompt("New name", old);
+      if (name !== null && name !== "" && name !== old) {
+        run(() => api("POST", "rename", { path: item.path, name: encode(name) }), childPath(parentOf(item.path), name), item.bucket);
+      }
+    },
+  };
+}
+
+function createBucket(parent) {
+  const name = prompt("Bucket name");
+  if (name) {
+    const path = childPath(parent, name);
+    if (parent) {
+      open.add(parent);
+    }
+    run(() => api("PUT", bucketURL(path)), path, true);
+  }
+}
+
+function bucketActions(item) {
+  return [
+    { text: "Create Bucket", run: () => createBucket(item.path) },
+    {
+      text: "Delete Bucket",
+      run: () => {
+        if (confirm("Delete bucket " + rawPath(item.path) + " and its contents?")) {
+          run(() => api("DELETE", bucketURL(item.path)), "");
+        }
+      },
+    },
+    {
+      text: "Empty Bucket",
+      run: () => {
+        if (confirm("Delete the contents of " + rawPath(item.path) + "?")) {
+          run(() => api("POST", "empty", { path: item.path }), item.path, true);
+        }
+      },
+    },
+    {
+      text: "Add Key",
+      run: () => {
+        const name = prompt("Key name");
+        if (!name) {
+          return;
+        }
+        const value = prompt("Value", "");
+        if (value === null) {
+          return;
+        }
+        const path = childPath(item.path, name);
+        open.add(item.path);
+        run(() => api("PUT", keyURL(path), value), path, false);
+      },
+    },
+    transferAction("Move Bucket", "move", item),
+    renameAction("Rename Bucket", item),
+    transferAction("Copy Bucket", "copy", item),
+  ];
+}
+
+function keyActions(item) {
+  return [
+    {
+      text: "Delete Key",
+      run: () => {
+        if (confirm("Delete key " + rawPath(item.path) + "?")) {
+          run(() => api("DELETE", keyURL(item.path)), "");
+        }
+      },
+    },
+    transferAction("Move Key", "move", item),
+    renameAction("Rename Key", item),
+    transferAction("Copy Key", "copy", item),
+  ];
+}
+
+// context menu
+
+function showMenu(item, x, y) {
+  menu.replaceChildren();
+  for (const action of item.bucket ? bucketActions(item) : keyActions(item)) {
+    const li = element("li", action.text);
+    li.addEventListener("click", () => {
+      menu.hidden = true;
+      action.run();
+    });
+    menu.appendChild(li);
+  }
+  menu.style.left = x + "px";
+  menu.style.top = y + "px";
+  menu.hidden = false;
+}
+
+document.addEventListener("click", () => (menu.hidden = true));
+document.addEventListener("keydown", (e) => {
+  if (e.key === "Escape") {
+    menu.hidden = true;
+  }
+});
+document.getElementById("create-bucket").addEventListener("click", () => createBucket(""));
+document.getElementById("reload").addEventListener("click", () => reload());
+
+loadTree();